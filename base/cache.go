@@ -0,0 +1,23 @@
+package base
+
+import (
+	"os"
+	"path/filepath"
+)
+
+/*
+CacheDir 返回transfer在用户缓存目录下的子目录，不存在时自动创建
+@name: 子目录名称
+*/
+func CacheDir(name string) (string, error) {
+	root, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(root, "transfer", name)
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -4,13 +4,20 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"io/fs"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	AwsMetaModTime = "mtime" // 保存原始修改时间的metadata
+	AwsMetaMode    = "mode"  // 保存原始文件权限的metadata
+)
+
 // AwsFileInfo 实现了os.FileInfo API的Aws专属FileInfo类
 type AwsFileInfo struct {
-	Object types.Object
+	Object   types.Object
+	Metadata map[string]string // HeadObject返回的用户自定义metadata
 }
 
 // Name 返回文件名
@@ -23,9 +30,12 @@ func (afi AwsFileInfo) Size() int64 {
 	return *afi.Object.Size
 }
 
-// Mode return the fake file mode for ftp file
+// Mode 返回metadata中记录的文件权限，不存在时返回0表示未知
 func (afi AwsFileInfo) Mode() fs.FileMode {
-	return fs.ModePerm
+	if mode, err := strconv.ParseUint(afi.Metadata[AwsMetaMode], 8, 32); err == nil {
+		return fs.FileMode(mode)
+	}
+	return 0
 }
 
 // IsDir 返回对象是否为文件夹，aws由于特殊walk机制，默认全为文件，仅通过后缀判断是否为文件夹
@@ -38,7 +48,10 @@ func (afi AwsFileInfo) Sys() any {
 	return afi.Object
 }
 
-// ModTime 返回最后修改时间
+// ModTime 返回metadata中记录的原始修改时间，不存在时返回对象的最后修改时间
 func (afi AwsFileInfo) ModTime() time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, afi.Metadata[AwsMetaModTime]); err == nil {
+		return t
+	}
 	return *afi.Object.LastModified
 }
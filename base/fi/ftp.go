@@ -29,9 +29,12 @@ func (ffi FtpFileInfo) Size() int64 {
 	return int64(ffi.File.Size)
 }
 
-// Mode return the fake file mode for ftp file
+// Mode ftp无法获取文件权限，仅返回是否为文件夹，权限为0表示未知
 func (ffi FtpFileInfo) Mode() fs.FileMode {
-	return fs.ModePerm
+	if ffi.IsDir() {
+		return fs.ModeDir
+	}
+	return 0
 }
 
 // IsDir 返回对象是否为文件夹
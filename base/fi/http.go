@@ -29,7 +29,7 @@ func (hfi HttpFileInfo) Size() int64 {
 	return hfi.SizeI
 }
 
-// Mode return the fake file mode for http file
+// Mode 返回服务器上文件的权限
 func (hfi HttpFileInfo) Mode() fs.FileMode {
 	return fs.FileMode(hfi.ModeI)
 }
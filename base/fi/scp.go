@@ -0,0 +1,49 @@
+package fi
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// ScpFileInfo 实现了os.FileInfo API的Scp专属FileInfo类，信息来自服务器上find的输出
+type ScpFileInfo struct {
+	Path     string
+	FileSize int64
+	Time     time.Time
+	FileMode fs.FileMode
+	Dir      bool
+}
+
+// Name 返回文件名
+func (sfi ScpFileInfo) Name() string {
+	return filepath.Base(sfi.Path)
+}
+
+// Size 返回文件大小
+func (sfi ScpFileInfo) Size() int64 {
+	return sfi.FileSize
+}
+
+// Mode 返回文件权限
+func (sfi ScpFileInfo) Mode() fs.FileMode {
+	if sfi.Dir {
+		return sfi.FileMode | fs.ModeDir
+	}
+	return sfi.FileMode
+}
+
+// ModTime 返回文件修改时间
+func (sfi ScpFileInfo) ModTime() time.Time {
+	return sfi.Time
+}
+
+// IsDir 返回是否为文件夹
+func (sfi ScpFileInfo) IsDir() bool {
+	return sfi.Dir
+}
+
+// Sys 无底层数据
+func (sfi ScpFileInfo) Sys() any {
+	return nil
+}
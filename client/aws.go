@@ -1,27 +1,33 @@
 package client
 
 import (
+	"bytes"
 	"context"
-	"crypto/md5"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/ygidtu/transfer/base/fi"
 	"gopkg.in/ini.v1"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // AwsS3Client 连接的配置
 type AwsS3Client struct {
-	Host   *Proxy
-	Proxy  *Proxy
-	client *s3.Client
-	Bucket string
+	Host     *Proxy
+	Proxy    *Proxy
+	client   *s3.Client
+	Bucket   string
+	endpoint string // 服务器地址、region及账号，用于判断两个客户端能否在服务器端直接复制
 }
 
 /*
@@ -92,6 +98,15 @@ func (asc *AwsS3Client) connect() error {
 	// Create an Amazon S3 service client
 	asc.client = s3.NewFromConfig(cfg)
 
+	// 同一region下不同账号的bucket不能直接复制，以access key区分账号，无法获取时以profile区分
+	identity := asc.Host.Host
+	if cfg.Credentials != nil {
+		if creds, err := cfg.Credentials.Retrieve(context.TODO()); err == nil && creds.AccessKeyID != "" {
+			identity = creds.AccessKeyID
+		}
+	}
+	asc.endpoint = fmt.Sprintf("%s|%s|%s", aws.ToString(cfg.BaseEndpoint), cfg.Region, identity)
+
 	if asc.Bucket == "" {
 		output, err := asc.client.ListBuckets(context.TODO(), &s3.ListBucketsInput{})
 		if err != nil {
@@ -112,62 +127,92 @@ func (asc *AwsS3Client) close() error {
 	return nil
 }
 
+/*
+prefix 将路径转换为目录的前缀
+@path: 目录路径
+*/
+func (asc *AwsS3Client) prefix(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" || path == "." {
+		return ""
+	}
+	return path + "/"
+}
+
+/*
+walk 借助paginator遍历prefix下的所有对象，避免单次请求1000个对象的限制
+@prefix: 对象的前缀
+@fn: 对每个对象的处理函数
+*/
+func (asc *AwsS3Client) walk(prefix string, fn func(object types.Object) error) error {
+	paginator := s3.NewListObjectsV2Paginator(asc.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(asc.Bucket), Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		for _, object := range output.Contents {
+			if err := fn(object); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // listFiles 借助aws的prefix列出所有符合prefix的文件路径
 func (asc *AwsS3Client) listFiles(src *File) (FileList, error) {
 	files := FileList{Files: []*File{}, Total: 0}
 
-	output, err := asc.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(asc.Bucket), Prefix: aws.String(src.Path),
-	})
-	if err != nil {
-		return files, err
+	// 文件直接返回，否则作为目录列出其下所有文件
+	if stat, err := asc.stat(src.Path); err == nil {
+		files.Files = append(files.Files, &File{
+			Path: src.Path, Size: stat.Size(), ModTime: stat.ModTime(), IsFile: true, client: asc,
+		})
+		files.Total += stat.Size()
+		return files, nil
 	}
 
-	for _, object := range output.Contents {
+	err := asc.walk(asc.prefix(src.Path), func(object types.Object) error {
+		if strings.HasSuffix(*object.Key, "/") {
+			return nil
+		}
 		files.Files = append(files.Files, &File{
-			Path: *object.Key, Size: *object.Size,
+			Path: *object.Key, Size: *object.Size, ModTime: aws.ToTime(object.LastModified),
 			IsFile: true, client: asc,
 		})
 		files.Total += *object.Size
-	}
-	return files, nil
+		return nil
+	})
+	return files, err
 }
 
-// exists 判断某个文件是否存在，根据prefix查文件，并判断key和输入的path是否一致
+// exists 判断某个文件或目录是否存在，文件通过HeadObject判断，目录则判断prefix下是否有对象
 func (asc *AwsS3Client) exists(path string) bool {
+	if _, err := asc.stat(path); err == nil {
+		return true
+	}
+
 	output, err := asc.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(asc.Bucket), Prefix: aws.String(path),
+		Bucket: aws.String(asc.Bucket), Prefix: aws.String(asc.prefix(path)), MaxKeys: aws.Int32(1),
 	})
 	if err != nil {
 		return false
 	}
-
-	for _, object := range output.Contents {
-		if strings.TrimRight(path, "/") == strings.TrimRight(*object.Key, "/") {
-			return true
-		}
-	}
-	return false
+	return len(output.Contents) > 0
 }
 
-// newFile 在aws client无用
+// newFile 根据HeadObject的结果生成文件对象，对象不存在时视为目录
 func (asc *AwsS3Client) newFile(path string) (*File, error) {
 	path = strings.TrimLeft(path, "/")
-	if asc.exists(path) {
-		output, err := asc.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-			Bucket: aws.String(asc.Bucket), Prefix: aws.String(path),
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		for _, object := range output.Contents {
-			if path == *object.Key {
-				return &File{Path: path, Size: *object.Size, client: asc, IsFile: true}, nil
-			}
-		}
+	if stat, err := asc.stat(path); err == nil {
+		return &File{Path: path, Size: stat.Size(), client: asc, IsFile: true}, nil
 	}
-	return &File{Path: path, Size: 0, client: asc, IsFile: true}, nil
+	return &File{Path: path, Size: 0, client: asc, IsFile: false}, nil
 }
 
 // mkdir 创建文件夹
@@ -186,48 +231,79 @@ func (asc *AwsS3Client) mkParent(path string) error {
 	return asc.mkdir(filepath.Dir(path))
 }
 
-// getMd5 根据文件的大小，有选择的掐头去尾创建MD5
+// getMd5 根据--checksum获取文件的校验值，优先使用服务器上记录的ETag或checksum，避免下载文件
 func (asc *AwsS3Client) getMd5(file *File) error {
-	log.Debugf("get md5 of %s", file.Path)
+	log.Debugf("get checksum of %s", file.Path)
 	stat, err := asc.stat(file.Path)
-	if !os.IsNotExist(err) {
-		var data []byte
-		r, err := asc.reader(file.Path, 0)
-		if err != nil {
-			return err
-		}
-		if stat.Size() < fileSizeLimit {
-			data, err = io.ReadAll(r)
-			if err := r.Close(); err != nil {
-				return err
-			}
-		} else {
-			data = make([]byte, capacity)
-			_, err = r.Read(data[:capacity/2])
-			if err != nil {
-				return err
-			}
-			if err := r.Close(); err != nil {
-				return err
-			}
-			r, err = asc.reader(file.Path, stat.Size()-capacity/2)
-			if err != nil {
-				return err
-			}
-			if err := r.Close(); err != nil {
-				return err
-			}
-		}
-		if err != nil {
-			return err
-		}
+	if err != nil {
+		return err
+	}
 
-		file.Md5 = fmt.Sprintf("%x", md5.Sum(data))
+	alg := checksumAlgorithm()
+	if res := asc.remoteChecksum(file.Path, alg); res != "" {
+		file.Md5 = res
+		return nil
 	}
 
+	file.Md5, err = streamChecksum(asc, file.Path, stat.Size(), alg)
 	return err
 }
 
+/*
+remoteChecksum 返回服务器上记录的文件校验值，分片上传的复合校验值无法使用，返回空字符串
+@path: 文件路径
+@alg: 校验算法
+*/
+func (asc *AwsS3Client) remoteChecksum(path, alg string) string {
+	if alg != ChecksumMd5 && alg != ChecksumSha256 && alg != ChecksumCrc32c {
+		return ""
+	}
+
+	output, err := asc.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(asc.Bucket), Key: aws.String(path), ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return ""
+	}
+
+	var value string
+	switch alg {
+	case ChecksumMd5:
+		// 非分片上传且未加密的对象，ETag即为md5
+		etag := strings.Trim(aws.ToString(output.ETag), "\"")
+		if len(etag) == 32 && output.ServerSideEncryption != types.ServerSideEncryptionAwsKms {
+			return etag
+		}
+		return ""
+	case ChecksumSha256:
+		value = aws.ToString(output.ChecksumSHA256)
+	case ChecksumCrc32c:
+		value = aws.ToString(output.ChecksumCRC32C)
+	}
+
+	if value == "" || strings.Contains(value, "-") {
+		return ""
+	}
+	res, err := base64ToHex(value)
+	if err != nil {
+		return ""
+	}
+	return res
+}
+
+/*
+checksumAlgorithm 返回上传时要求aws计算的checksum算法，便于之后直接校验
+*/
+func (asc *AwsS3Client) checksumAlgorithm() types.ChecksumAlgorithm {
+	switch checksumAlgorithm() {
+	case ChecksumSha256:
+		return types.ChecksumAlgorithmSha256
+	case ChecksumCrc32c:
+		return types.ChecksumAlgorithmCrc32c
+	}
+	return ""
+}
+
 // reader 创建远程文件的ReadCloser
 func (asc *AwsS3Client) reader(path string, offset int64) (io.ReadCloser, error) {
 	result, err := asc.client.GetObject(context.TODO(), &s3.GetObjectInput{
@@ -248,35 +324,180 @@ func (asc *AwsS3Client) writeAt(_ io.Reader, _ string, _ bool) error {
 	return fmt.Errorf("aws do not support writeAt, please use write instead")
 }
 
-// write 写出完整文件
-func (asc *AwsS3Client) write(reader io.ReadSeeker, path string) error {
-	if asc.mkParent(path) == nil {
-		_, err := asc.client.PutObject(context.TODO(), &s3.PutObjectInput{
-			Bucket: aws.String(asc.Bucket),
-			Key:    aws.String(path),
-			Body:   reader,
-		})
+/*
+write 写出完整文件，小文件直接上传，大文件则分片并行上传
+@src: 源文件
+@path: 目标文件路径
+@progress: 每完成一部分数据时的回调
+*/
+func (asc *AwsS3Client) write(src *File, path string, progress func(int64)) error {
+	// 同一服务器上的文件直接在服务器端复制
+	if other, ok := src.client.(*AwsS3Client); ok && other.endpoint == asc.endpoint {
+		err := asc.copy(other, src, path)
+		if err == nil {
+			progress(src.Size)
+			return nil
+		}
+		log.Warnf("failed to copy %s on server side, fallback to streaming: %v", src.Path, err)
+	}
 
+	if src.Size >= partSize(src.Size) {
+		return asc.multipartUpload(src, path, progress)
+	}
+
+	r, err := src.Reader(0)
+	if err != nil {
 		return err
 	}
-	return nil
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = asc.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:            aws.String(asc.Bucket),
+		Key:               aws.String(path),
+		Body:              bytes.NewReader(data),
+		ChecksumAlgorithm: asc.checksumAlgorithm(),
+		Metadata:          asc.metadata(preserveStat(src)),
+	})
+	if err == nil {
+		progress(int64(len(data)))
+	}
+	return err
+}
+
+/*
+copy 在服务器端直接复制文件，大于5G的文件通过UploadPartCopy分片复制
+@from: 源文件所在的客户端
+@src: 源文件
+@path: 目标文件路径
+*/
+func (asc *AwsS3Client) copy(from *AwsS3Client, src *File, path string) error {
+	source := url.PathEscape(from.Bucket) + "/" + strings.ReplaceAll(url.PathEscape(src.Path), "%2F", "/")
+	if src.Size > maxCopySize {
+		return asc.multipartCopy(source, src.Size, path)
+	}
+
+	_, err := asc.client.CopyObject(context.TODO(), &s3.CopyObjectInput{
+		Bucket:     aws.String(asc.Bucket),
+		Key:        aws.String(path),
+		CopySource: aws.String(source),
+	})
+	return err
 }
 
-// stat 列出目标文件的信息
+// stat 通过HeadObject获取目标文件的信息
 func (asc *AwsS3Client) stat(path string) (os.FileInfo, error) {
-	if asc.exists(path) {
-		output, err := asc.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-			Bucket: aws.String(asc.Bucket), Prefix: aws.String(path),
-		})
-		if err != nil {
-			return nil, err
+	path = strings.TrimLeft(path, "/")
+	if path == "" {
+		return nil, os.ErrNotExist
+	}
+
+	output, err := asc.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(asc.Bucket), Key: aws.String(path),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, os.ErrNotExist
 		}
+		return nil, err
+	}
 
-		for _, object := range output.Contents {
-			if strings.TrimRight(path, "/") == strings.TrimRight(*object.Key, "/") {
-				return fi.AwsFileInfo{Object: object}, nil
-			}
+	return fi.AwsFileInfo{Object: types.Object{
+		Key:          aws.String(path),
+		Size:         output.ContentLength,
+		ETag:         output.ETag,
+		LastModified: output.LastModified,
+	}, Metadata: output.Metadata}, nil
+}
+
+// remove 删除目标文件
+func (asc *AwsS3Client) remove(path string) error {
+	_, err := asc.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: aws.String(asc.Bucket),
+		Key:    aws.String(path),
+	})
+	return err
+}
+
+/*
+setStat 通过对象的metadata记录修改时间和权限，若metadata不一致则在服务器端复制自身以替换metadata
+@path: 文件路径
+@modTime: 修改时间，为空时不修改
+@mode: 文件权限，为0时不修改
+*/
+func (asc *AwsS3Client) setStat(path string, modTime time.Time, mode os.FileMode) error {
+	stat, err := asc.stat(path)
+	if err != nil {
+		return err
+	}
+	info := stat.(fi.AwsFileInfo)
+
+	metadata := make(map[string]string)
+	for k, v := range info.Metadata {
+		metadata[k] = v
+	}
+	for k, v := range asc.metadata(modTime, mode) {
+		metadata[k] = v
+	}
+
+	changed := false
+	for k, v := range metadata {
+		if info.Metadata[k] != v {
+			changed = true
 		}
 	}
-	return nil, os.ErrNotExist
+	if !changed {
+		return nil
+	}
+
+	if stat.Size() > maxCopySize {
+		return fmt.Errorf("failed to set metadata of %s, which larger than 5G", path)
+	}
+
+	source := url.PathEscape(asc.Bucket) + "/" + strings.ReplaceAll(url.PathEscape(path), "%2F", "/")
+	_, err = asc.client.CopyObject(context.TODO(), &s3.CopyObjectInput{
+		Bucket:            aws.String(asc.Bucket),
+		Key:               aws.String(path),
+		CopySource:        aws.String(source),
+		Metadata:          metadata,
+		MetadataDirective: types.MetadataDirectiveReplace,
+	})
+	return err
+}
+
+/*
+metadata 生成记录修改时间和权限的metadata
+@modTime: 修改时间，为空时不记录
+@mode: 文件权限，为0时不记录
+*/
+func (asc *AwsS3Client) metadata(modTime time.Time, mode os.FileMode) map[string]string {
+	metadata := make(map[string]string)
+	if !modTime.IsZero() {
+		metadata[fi.AwsMetaModTime] = modTime.UTC().Format(time.RFC3339Nano)
+	}
+	if mode != 0 {
+		metadata[fi.AwsMetaMode] = strconv.FormatUint(uint64(mode.Perm()), 8)
+	}
+	return metadata
+}
+
+/*
+rename aws不支持重命名，通过服务器端复制后删除原文件实现
+@src: 原路径
+@dst: 新路径
+*/
+func (asc *AwsS3Client) rename(src, dst string) error {
+	file, err := asc.newFile(src)
+	if err != nil {
+		return err
+	}
+	if err := asc.copy(asc, file, dst); err != nil {
+		return err
+	}
+	return asc.remove(src)
 }
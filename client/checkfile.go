@@ -0,0 +1,166 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+)
+
+// sftp协议中check-file扩展所需的数据包类型
+const (
+	sshFxpInit          = 1
+	sshFxpVersion       = 2
+	sshFxpStatus        = 101
+	sshFxpExtended      = 200
+	sshFxpExtendedReply = 201
+)
+
+// checkFileAlgorithms check-file扩展支持且与--checksum对应的算法
+var checkFileAlgorithms = map[string]bool{ChecksumMd5: true, ChecksumSha256: true}
+
+// sftpPacket 构建sftp数据包的内容
+type sftpPacket struct {
+	bytes.Buffer
+}
+
+func (p *sftpPacket) uint32(v uint32) {
+	_ = binary.Write(p, binary.BigEndian, v)
+}
+
+func (p *sftpPacket) uint64(v uint64) {
+	_ = binary.Write(p, binary.BigEndian, v)
+}
+
+func (p *sftpPacket) string(v string) {
+	p.uint32(uint32(len(v)))
+	p.WriteString(v)
+}
+
+// send 发送数据包，长度包含类型字节
+func (p *sftpPacket) send(w io.Writer, packetType byte) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header, uint32(p.Len()+1))
+	header[4] = packetType
+	_, err := w.Write(append(header, p.Bytes()...))
+	return err
+}
+
+// readSftpPacket 读取一个sftp数据包，返回类型和内容
+func readSftpPacket(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length < 1 || length > 256*1024 {
+		return 0, nil, fmt.Errorf("invalid sftp packet length %d", length)
+	}
+	data := make([]byte, length-1)
+	_, err := io.ReadFull(r, data)
+	return header[4], data, err
+}
+
+// readSftpString 从数据包中读取字符串，返回字符串和剩余的内容
+func readSftpString(data []byte) (string, []byte, error) {
+	if len(data) < 4 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	length := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < length {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return string(data[4 : 4+length]), data[4+length:], nil
+}
+
+/*
+sftpCheckFile 通过sftp的check-file扩展由服务器计算整个文件的校验值；
+pkg/sftp的客户端不支持发送任意的扩展请求，因此单独打开一个sftp子系统
+@client: ssh client
+@path: 文件路径
+@alg: 校验算法，仅支持md5和sha256
+*/
+func sftpCheckFile(client *ssh.Client, path, alg string) (string, error) {
+	if !checkFileAlgorithms[alg] {
+		return "", fmt.Errorf("check-file do not support %s", alg)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return "", err
+	}
+
+	init := &sftpPacket{}
+	init.uint32(3)
+	if err := init.send(stdin, sshFxpInit); err != nil {
+		return "", err
+	}
+	if packetType, _, err := readSftpPacket(stdout); err != nil {
+		return "", err
+	} else if packetType != sshFxpVersion {
+		return "", fmt.Errorf("unexpected sftp packet %d", packetType)
+	}
+
+	// 从文件开头计算至结尾，block-size为0表示整个文件只计算一个校验值
+	request := &sftpPacket{}
+	request.uint32(1)
+	request.string("check-file-name")
+	request.string(path)
+	request.string(alg)
+	request.uint64(0)
+	request.uint64(0)
+	request.uint32(0)
+	if err := request.send(stdin, sshFxpExtended); err != nil {
+		return "", err
+	}
+
+	packetType, data, err := readSftpPacket(stdout)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < 4 {
+		return "", io.ErrUnexpectedEOF
+	}
+	data = data[4:]
+
+	switch packetType {
+	case sshFxpStatus:
+		if len(data) < 4 {
+			return "", io.ErrUnexpectedEOF
+		}
+		msg, _, _ := readSftpString(data[4:])
+		return "", fmt.Errorf("check-file failed with status %d: %s", binary.BigEndian.Uint32(data), msg)
+	case sshFxpExtendedReply:
+		// 部分服务器在回复中先返回扩展名称check-file，再返回使用的算法
+		used, rest, err := readSftpString(data)
+		if err != nil {
+			return "", err
+		}
+		if used == "check-file" {
+			if used, rest, err = readSftpString(rest); err != nil {
+				return "", err
+			}
+		}
+		if used != alg || len(rest) == 0 {
+			return "", fmt.Errorf("check-file returned unexpected %s hash", used)
+		}
+		return hex.EncodeToString(rest), nil
+	}
+	return "", fmt.Errorf("unexpected sftp packet %d", packetType)
+}
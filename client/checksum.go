@@ -0,0 +1,110 @@
+package client
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	ChecksumHeadTail = "head-tail" // 小文件完整md5，大文件头尾md5
+	ChecksumMd5      = "md5"
+	ChecksumSha256   = "sha256"
+	ChecksumXxh3     = "xxh3"
+	ChecksumCrc32c   = "crc32c"
+	ChecksumBlake3   = "blake3"
+)
+
+// ErrChecksumMismatch 传输完成后源文件和目标文件的校验值不一致
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+/*
+newHash 根据算法名称生成对应的hash
+@alg: 校验算法
+*/
+func newHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case ChecksumHeadTail, ChecksumMd5:
+		return md5.New(), nil
+	case ChecksumSha256:
+		return sha256.New(), nil
+	case ChecksumXxh3:
+		return xxh3.New(), nil
+	case ChecksumCrc32c:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	case ChecksumBlake3:
+		return blake3.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm: %s", alg)
+}
+
+/*
+checksumAlgorithm 返回--checksum指定的校验算法，未指定时为head-tail
+*/
+func checksumAlgorithm() string {
+	if opt == nil || opt.Checksum == "" {
+		return ChecksumHeadTail
+	}
+	return opt.Checksum
+}
+
+/*
+base64ToHex 将aws等服务器返回的base64格式校验值转换为16进制
+@value: base64格式的校验值
+*/
+func base64ToHex(value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+/*
+streamChecksum 通过客户端的reader流式计算文件的校验值，不会将整个文件读入内存
+@client: 文件所在的客户端
+@path: 文件路径
+@size: 文件大小
+@alg: 校验算法
+*/
+func streamChecksum(client Client, path string, size int64, alg string) (string, error) {
+	h, err := newHash(alg)
+	if err != nil {
+		return "", err
+	}
+
+	if alg == ChecksumHeadTail && size >= fileSizeLimit {
+		// 文件大于10M，则从头尾各取一部分计算MD5
+		for _, offset := range []int64{0, size - capacity/2} {
+			r, err := client.reader(path, offset)
+			if err != nil {
+				return "", err
+			}
+			_, err = io.CopyN(h, r, capacity/2)
+			_ = r.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	} else {
+		r, err := client.reader(path, 0)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+
+		if _, err := io.Copy(h, r); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
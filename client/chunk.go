@@ -0,0 +1,181 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// writerAtCloser 支持在任意位置写入的文件
+type writerAtCloser interface {
+	io.WriterAt
+	io.Closer
+}
+
+// positionalWriter 支持位置写入的客户端，大文件可以分块并行写入
+type positionalWriter interface {
+	writerAt(path string, trunc bool) (writerAtCloser, error)
+}
+
+// sectionWriter 从特定位置开始顺序写入的writer
+type sectionWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (s *sectionWriter) Write(p []byte) (int, error) {
+	n, err := s.w.WriteAt(p, s.offset)
+	s.offset += int64(n)
+	return n, err
+}
+
+// progressReader 每次读取数据后汇报进度
+type progressReader struct {
+	io.Reader
+	progress func(int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.progress(int64(n))
+	return n, err
+}
+
+/*
+chunkable 检查文件是否可以分块并行传输，要求多线程、目标支持位置写入，
+且源文件的客户端支持按区间读取，ftp只能读取至文件结尾，提前关闭数据连接会中断传输
+@src: 源文件
+@plan: 传输计划
+*/
+func (transfer *Transfer) chunkable(src *File, plan *Plan) bool {
+	if plan.chunked {
+		return true
+	}
+	if transfer.concurrent < 2 || src.Source() == Ftp {
+		return false
+	}
+	if _, ok := plan.writer.client.(positionalWriter); !ok {
+		return false
+	}
+	return plan.Bytes >= 2*partSize(src.Size)
+}
+
+/*
+chunkPlan 上次分块传输中断的文件内部可能有空洞，无法通过文件大小或校验值判断，
+只能从日志记录的连续位置继续分块传输；没有分块传输的记录时返回nil
+@src: 源文件
+@dst: 目标文件
+*/
+func (transfer *Transfer) chunkPlan(src, dst *File) *Plan {
+	record := transfer.journal.get(src)
+	if record == nil || !record.Chunked || record.State == JournalDone || record.State == JournalVerified {
+		return nil
+	}
+
+	writer := dst
+	if opt.Atomic {
+		writer = dst.Partial()
+	}
+	// 目标文件已被删除或者截断
+	if writer.Size <= 0 || writer.Size < record.Offset {
+		return nil
+	}
+
+	plan := &Plan{
+		Action: ActionResume, Source: src.Path, Target: dst.Path,
+		Offset: record.Offset, Bytes: src.Size - record.Offset, writer: writer, chunked: true,
+	}
+	if plan.Offset == 0 {
+		plan.Action = ActionTruncate
+	}
+	return plan
+}
+
+/*
+chunkedCopy 将大文件拆分为多个区间，由多个线程分别读取并写入目标文件的对应位置；
+日志中记录已经连续完成的位置，中断后从该位置续传
+@src: 源文件
+@plan: 传输计划
+@progress: 传输进度的回调
+*/
+func (transfer *Transfer) chunkedCopy(src *File, plan *Plan, progress func(int64)) error {
+	w, err := plan.writer.client.(positionalWriter).writerAt(plan.writer.Path, plan.Action == ActionTruncate)
+	if err != nil {
+		return err
+	}
+
+	size := partSize(src.Size)
+	var offsets []int64
+	for offset := plan.Offset; offset < src.Size; offset += size {
+		offsets = append(offsets, offset)
+	}
+	log.Debugf("transfer %s in %d chunks", src.Path, len(offsets))
+
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		finished = make([]bool, len(offsets))
+		next     = 0 // 第一个未完成的分块
+		failed   int32
+		firstErr error
+	)
+
+	transfer.journal.chunk(src, plan.Offset)
+	tasks := make(chan int)
+	for i := 0; i < transfer.concurrent; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range tasks {
+				if atomic.LoadInt32(&failed) > 0 {
+					continue
+				}
+
+				start := offsets[idx]
+				length := size
+				if start+length > src.Size {
+					length = src.Size - start
+				}
+
+				err := func() error {
+					r, err := src.Reader(start)
+					if err != nil {
+						return err
+					}
+					defer r.Close()
+
+					_, err = io.CopyN(&sectionWriter{w: w, offset: start}, &progressReader{Reader: r, progress: progress}, length)
+					return err
+				}()
+
+				lock.Lock()
+				if err != nil {
+					if atomic.AddInt32(&failed, 1) == 1 {
+						firstErr = fmt.Errorf("failed to transfer %s from %d: %v", src.Path, start, err)
+					}
+				} else {
+					finished[idx] = true
+					for next < len(offsets) && finished[next] {
+						next++
+					}
+					if next < len(offsets) {
+						transfer.journal.chunk(src, offsets[next])
+					}
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	for idx := range offsets {
+		tasks <- idx
+	}
+	close(tasks)
+	wg.Wait()
+
+	if err := w.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
@@ -8,11 +8,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 var (
 	log           *zap.SugaredLogger
 	opt           *base.Options
+	filter        *Filter
 	capacity      = int64(2000)
 	fileSizeLimit = int64(10 * 1024 * 1024)
 )
@@ -24,6 +26,7 @@ const (
 	NoClient = "no-client"
 	Aws      = "aws-s3"
 	Sftp     = "sftp"
+	Scp      = "scp"
 	Ftp      = "ftp"
 	Http     = "http-client"
 	HttpS    = "http-server"
@@ -32,18 +35,21 @@ const (
 
 // Client 客户端的接口
 type Client interface {
-	clientType() TransferClientType                          // 返回客户端类型
-	connect() error                                          // 实现客户端的链接功能
-	close() error                                            // 关闭客户端
-	listFiles(src *File) (FileList, error)                   // 列出特定目录下的所有文件
-	exists(path string) bool                                 // 文件或文件夹是否存在
-	newFile(path string) (*File, error)                      // 根据客户端类型新建新的File
-	mkdir(path string) error                                 // 新建新的目录
-	mkParent(path string) error                              // 新建新的父目录
-	getMd5(file *File) error                                 // 获取不同客户端上文件的md5
-	reader(path string, offset int64) (io.ReadCloser, error) // 不同客户端上文件的reader
-	writeAt(reader io.Reader, path string, trunc bool) error // 在不同客户端文件的特定位置写入数据
-	stat(path string) (os.FileInfo, error)                   // 获取不同客户端上的文件信息
+	clientType() TransferClientType                                 // 返回客户端类型
+	connect() error                                                 // 实现客户端的链接功能
+	close() error                                                   // 关闭客户端
+	listFiles(src *File) (FileList, error)                          // 列出特定目录下的所有文件
+	exists(path string) bool                                        // 文件或文件夹是否存在
+	newFile(path string) (*File, error)                             // 根据客户端类型新建新的File
+	mkdir(path string) error                                        // 新建新的目录
+	mkParent(path string) error                                     // 新建新的父目录
+	getMd5(file *File) error                                        // 获取不同客户端上文件的md5
+	reader(path string, offset int64) (io.ReadCloser, error)        // 不同客户端上文件的reader
+	writeAt(reader io.Reader, path string, trunc bool) error        // 在不同客户端文件的特定位置写入数据
+	stat(path string) (os.FileInfo, error)                          // 获取不同客户端上的文件信息
+	remove(path string) error                                       // 删除不同客户端上的文件
+	rename(src, dst string) error                                   // 重命名不同客户端上的文件
+	setStat(path string, modTime time.Time, mode os.FileMode) error // 修改不同客户端上文件的修改时间和权限
 }
 
 /*
@@ -57,9 +63,11 @@ func clientFromProxy(host, proxy *Proxy, bucket string) (Client, error) {
 	var err error
 	if host.Scheme == "file" {
 		return NewLocal(), nil
+	} else if host.Scheme == "scp" || host.Scheme == "ssh" && opt.Scp {
+		client = NewScp(host, proxy, opt.IdRsa)
 	} else if host.Scheme == "ssh" {
-		client = NewSftp(host, proxy, opt.IdRsa, opt.Scp, opt.Concurrent)
-	} else if host.Scheme == "ftp" {
+		client = NewSftp(host, proxy, opt.IdRsa, opt.Conns, opt.Sessions)
+	} else if host.Scheme == "ftp" || host.Scheme == "ftps" {
 		client = NewFtp(host)
 	} else if strings.Contains(host.Scheme, "http") {
 		client, err = NewHTTPClient(host, proxy)
@@ -86,6 +94,21 @@ func InitClient(option *base.Options) (*Transfer, error) {
 	opt = option
 	var err error
 
+	if _, err = newHash(opt.Checksum); err != nil {
+		return nil, err
+	}
+
+	for _, attr := range strings.Split(opt.Preserve, ",") {
+		if attr = strings.TrimSpace(attr); attr != "" && attr != "times" && attr != "mode" {
+			return nil, fmt.Errorf("unsupported --preserve %s, should be times and/or mode", attr)
+		}
+	}
+
+	filter, err = NewFilter(opt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse filter rules: %v", err)
+	}
+
 	var proxy *Proxy
 	if opt.Proxy != "" {
 		proxy, err = CreateProxy(opt.Proxy)
@@ -94,6 +117,24 @@ func InitClient(option *base.Options) (*Transfer, error) {
 		}
 	}
 
+	// 从任务日志中读取源路径和目标路径
+	var journal *Journal
+	if opt.Resume != "" {
+		journal, err = LoadJournal(opt.Resume)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load job %s: %v", opt.Resume, err)
+		}
+		if opt.Source == "" {
+			opt.Source = journal.Source
+		}
+		if opt.Target == "" {
+			opt.Target = journal.Target
+		}
+		if opt.Source == "" {
+			return nil, fmt.Errorf("job %s not exists, please set source and target", opt.Resume)
+		}
+	}
+
 	if !strings.Contains(opt.Source, "://") {
 		abs, err := filepath.Abs(opt.Source)
 		if err != nil {
@@ -118,17 +159,36 @@ func InitClient(option *base.Options) (*Transfer, error) {
 	}
 
 	if opt.Server != "" {
-		if !strings.HasPrefix(opt.Server, "http") {
+		if !strings.Contains(opt.Server, "://") {
 			opt.Server = fmt.Sprintf("http://%s", opt.Server)
 		}
-		server, err := CreateProxy(opt.Server)
+		host, err := CreateProxy(opt.Server)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode server host url: %v", err)
 		}
-		serverClient, err := clientFromProxy(server, proxy, "")
-		source, err = NewFile(sourceP.Path, serverClient)
 
-		return &Transfer{concurrent: option.Concurrent, source: source}, nil
+		var srv server
+		if host.Scheme == "ftp" || host.Scheme == "ftps" {
+			srv, err = NewFtpServer(host, sourceP.Path)
+		} else if host.Scheme == "ssh" {
+			srv, err = NewSftpServer(host, sourceP.Path)
+		} else if host.Scheme == "s3" {
+			srv, err = NewS3Server(host, sourceP.Path)
+		} else if strings.Contains(host.Scheme, "http") {
+			var serverClient Client
+			serverClient, err = clientFromProxy(host, proxy, "")
+			if err == nil {
+				source, err = NewFile(sourceP.Path, serverClient)
+				srv = serverClient.(*HttpClient)
+			}
+		} else {
+			err = fmt.Errorf("unsupported server scheme = %s", host.Scheme)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create server: %v", err)
+		}
+
+		return &Transfer{concurrent: option.Concurrent, source: source, server: srv}, nil
 	} else {
 		if opt.Target == "" {
 			opt.Target = opt.Source
@@ -155,6 +215,19 @@ func InitClient(option *base.Options) (*Transfer, error) {
 			return nil, fmt.Errorf("failed to get target file/directory: %v", err)
 		}
 
-		return &Transfer{concurrent: option.Concurrent, source: source, target: target}, nil
+		if journal == nil {
+			journal, err = LoadJournal(journalID(opt.Source, opt.Target))
+			if err != nil {
+				return nil, fmt.Errorf("failed to load journal: %v", err)
+			}
+		}
+		if !opt.DryRun {
+			if err := journal.open(opt.Source, opt.Target); err != nil {
+				return nil, err
+			}
+			log.Infof("job id: %s, resume this job by --resume %s", journal.ID, journal.ID)
+		}
+
+		return &Transfer{concurrent: option.Concurrent, source: source, target: target, journal: journal}, nil
 	}
 }
@@ -1,22 +1,26 @@
 package client
 
 import (
+	"crypto/md5"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // File is used to kept file path and size
 type File struct {
-	Path   string // 文件路径
-	Size   int64  // 文件大小
-	IsFile bool   // 是否为文件
-	IsLink bool   // 是否为软连接
-	ID     string // 文件传输id
-	Md5    string // 文件的md5，文件过大时为头尾md5
-	client Client // 文件的来源客户端
+	Path    string      // 文件路径
+	Size    int64       // 文件大小
+	ModTime time.Time   // 文件的最后修改时间
+	Mode    os.FileMode // 文件权限
+	IsFile  bool        // 是否为文件
+	IsLink  bool        // 是否为软连接
+	ID      string      // 文件传输id
+	Md5     string      // 文件的校验值，由--checksum决定算法，默认文件过大时为头尾md5
+	client  Client      // 文件的来源客户端
 }
 
 // FileList connect list of files
@@ -25,6 +29,11 @@ type FileList struct {
 	Total int64
 }
 
+// Name returns the base name of file
+func (file *File) Name() string {
+	return filepath.Base(file.Path)
+}
+
 func (file *File) ShortID() string {
 	fn := []rune(file.Name())
 
@@ -36,8 +45,14 @@ func (file *File) ShortID() string {
 	return string(fn)
 }
 
-// GetTarget generate the target path
-func (file *File) GetTarget(source, target *File) *File {
+// GetTargetPath generate the target path without touching the target client
+func (file *File) GetTargetPath(source, target *File) string {
+	// 源为单个文件且指定的target的文件与其名字相同，则不再使用join合并通路
+	// 源为目录时不能使用，否则子目录中与target同名的文件会被映射到target本身
+	if source.IsFile && filepath.Base(target.Path) == filepath.Base(file.Path) {
+		return target.Path
+	}
+
 	sourcePath := source.Path
 	if source.IsFile {
 		sourcePath = filepath.Dir(source.Path)
@@ -45,12 +60,12 @@ func (file *File) GetTarget(source, target *File) *File {
 	path := strings.Replace(file.Path, sourcePath, "", 1)
 	path = strings.TrimLeft(path, "/")
 
-	dst := &File{Path: filepath.Join(target.Path, path), IsFile: source.IsFile, client: target.client}
+	return filepath.Join(target.Path, path)
+}
 
-	// 如果指定的target的文件与待传输文件名字相同，则不再使用join合并通路
-	if filepath.Base(target.Path) == filepath.Base(file.Path) {
-		dst = &File{Path: target.Path, IsFile: source.IsFile, client: target.client}
-	}
+// GetTarget generate the target file
+func (file *File) GetTarget(source, target *File) *File {
+	dst := &File{Path: file.GetTargetPath(source, target), IsFile: source.IsFile, client: target.client}
 
 	stat, _ := dst.Stat()
 	if stat != nil {
@@ -61,6 +76,31 @@ func (file *File) GetTarget(source, target *File) *File {
 	return dst
 }
 
+// IsPartial checks whether file is the temporary file of --atomic mode
+func (file *File) IsPartial() bool {
+	name := file.Name()
+	return strings.HasPrefix(name, ".transfer-") && strings.HasSuffix(name, ".partial")
+}
+
+// Partial returns the temporary file next to file, which is used to write data in --atomic mode
+func (file *File) Partial() *File {
+	id := fmt.Sprintf("%x", md5.Sum([]byte(file.Path)))[:16]
+	partial := &File{
+		Path:   filepath.Join(filepath.Dir(file.Path), fmt.Sprintf(".transfer-%s.partial", id)),
+		IsFile: true, client: file.client,
+	}
+
+	if stat, err := partial.Stat(); err == nil && stat != nil {
+		partial.Size = stat.Size()
+	}
+	return partial
+}
+
+// Rename move file to the path of target on the same client
+func (file *File) Rename(target *File) error {
+	return file.client.rename(file.Path, target.Path)
+}
+
 // NewFile create new File object
 func NewFile(path string, client Client) (*File, error) {
 	return client.newFile(path)
@@ -88,15 +128,21 @@ func (file *File) WriteAt(reader io.Reader, trunc bool) error {
 	return file.client.writeAt(reader, file.Path, trunc)
 }
 
+// fileWriter 需要完整上传源文件的客户端，如aws和scp
+type fileWriter interface {
+	write(src *File, path string, progress func(int64)) error
+}
+
 /*
-Write is used to write file from beginning
-@reader: the source file reader
+Write is used to write file from beginning, only for aws and scp, large files are uploaded to aws in resumable parts
+@src: the source file
+@progress: callback after every piece of data uploaded
 */
-func (file *File) Write(reader io.ReadSeeker) error {
-	if file.Source() == Aws {
-		return file.client.(*AwsS3Client).write(reader, file.Path)
+func (file *File) Write(src *File, progress func(int64)) error {
+	if writer, ok := file.client.(fileWriter); ok {
+		return writer.write(src, file.Path, progress)
 	}
-	return fmt.Errorf("only Aws client has Write")
+	return fmt.Errorf("only Aws and Scp client has Write")
 }
 
 // GetMd5 calculate the md5 hash of partial file
@@ -109,6 +155,20 @@ func (file *File) Exists() bool {
 	return file.client.exists(file.Path)
 }
 
+// Remove used to delete file from client
+func (file *File) Remove() error {
+	return file.client.remove(file.Path)
+}
+
+/*
+SetStat used to change the modification time and permission of file
+@modTime: the modification time, zero means unchanged
+@mode: the permission, zero means unchanged
+*/
+func (file *File) SetStat(modTime time.Time, mode os.FileMode) error {
+	return file.client.setStat(file.Path, modTime, mode)
+}
+
 // MkParent used to create parent directory of file
 func (file *File) MkParent() error {
 	return file.client.mkParent(file.Path)
@@ -119,9 +179,13 @@ func (file *File) Stat() (os.FileInfo, error) {
 	return file.client.stat(file.Path)
 }
 
-// Children call listFiles from client, to get all children files under directory or file itself
+// Children call listFiles from client, to get all children files under directory or file itself, filtered by include/exclude rules
 func (file *File) Children() (FileList, error) {
-	return file.client.listFiles(file)
+	files, err := file.client.listFiles(file)
+	if err != nil {
+		return files, err
+	}
+	return filter.apply(file, files), nil
 }
 
 // Source offers the client type
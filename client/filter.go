@@ -0,0 +1,348 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ygidtu/transfer/base"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filterRule 单条include/exclude规则
+type filterRule struct {
+	pattern string         // 原始的glob规则
+	regex   *regexp.Regexp // glob转换成的正则
+	include bool           // include还是exclude
+	dirOnly bool           // 以/结尾的规则仅匹配目录
+	anchor  bool           // 包含/的规则匹配相对路径，否则仅匹配文件名
+}
+
+// Filter 所有客户端共用的文件过滤规则
+type Filter struct {
+	rules          []*filterRule
+	minSize        int64
+	maxSize        int64
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+	skipHidden     bool
+}
+
+/*
+globToRegexp 将rsync风格的glob转换为正则，**匹配任意路径，*和?不匹配/
+@pattern: glob规则
+*/
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+/*
+newFilterRule 解析单条规则
+@pattern: glob规则
+@include: include还是exclude
+*/
+func newFilterRule(pattern string, include bool) (*filterRule, error) {
+	rule := &filterRule{pattern: pattern, include: include}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if strings.Contains(pattern, "/") {
+		rule.anchor = true
+		pattern = strings.TrimLeft(pattern, "/")
+	}
+
+	var err error
+	rule.regex, err = globToRegexp(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %v", rule.pattern, err)
+	}
+	return rule, nil
+}
+
+/*
+match 检查相对路径是否符合规则
+@rel: 相对于列出目录的路径
+@isDir: 是否为目录
+*/
+func (rule *filterRule) match(rel string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	if rule.anchor {
+		return rule.regex.MatchString(rel)
+	}
+	return rule.regex.MatchString(path.Base(rel))
+}
+
+/*
+parseSize 解析带有K/M/G/T单位的文件大小
+@size: 文件大小，如10M
+*/
+func parseSize(size string) (int64, error) {
+	size = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+	if size == "" {
+		return 0, nil
+	}
+
+	unit := int64(1)
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(size, suffix) {
+			unit = int64(1) << (10 * (i + 1))
+			size = strings.TrimSuffix(size, suffix)
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+	return int64(value * float64(unit)), nil
+}
+
+/*
+parseTime 解析日期或RFC3339格式的时间
+@value: 时间字符串
+*/
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s, should be 2006-01-02, 2006-01-02 15:04:05 or RFC3339", value)
+}
+
+/*
+NewFilter 根据命令行参数生成文件过滤规则，规则按照--include、--exclude、--exclude-from的顺序依次检查，
+与rsync不同，--include规则总是先于--exclude规则，与其在命令行中的顺序无关；
+设置了--include时，未匹配任何规则的文件不传输
+@option: 命令行参数
+*/
+func NewFilter(option *base.Options) (*Filter, error) {
+	f := &Filter{skipHidden: option.Skip}
+	var err error
+
+	for _, pattern := range option.Include {
+		rule, err := newFilterRule(pattern, true)
+		if err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, rule)
+	}
+
+	for _, pattern := range option.Exclude {
+		rule, err := newFilterRule(pattern, false)
+		if err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, rule)
+	}
+
+	if option.ExcludeFrom != "" {
+		rules, err := readFilterRules(option.ExcludeFrom)
+		if err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, rules...)
+	}
+
+	// 相当于rsync中在最后添加--exclude '*'，但不影响目录的遍历
+	if len(option.Include) > 0 {
+		rule, err := newFilterRule("*", false)
+		if err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, rule)
+	}
+
+	if f.minSize, err = parseSize(option.MinSize); err != nil {
+		return nil, err
+	}
+	if f.maxSize, err = parseSize(option.MaxSize); err != nil {
+		return nil, err
+	}
+	if f.modifiedAfter, err = parseTime(option.ModifiedAfter); err != nil {
+		return nil, err
+	}
+	if f.modifiedBefore, err = parseTime(option.ModifiedBefore); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+/*
+readFilterRules 从文件中读取exclude规则，每行一条，空行和#开头的行被忽略；
+与rsync类似，"+ "开头的行为include规则，"- "开头的行为exclude规则
+@path: 规则文件路径
+*/
+func readFilterRules(path string) ([]*filterRule, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer r.Close()
+
+	var rules []*filterRule
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		include := false
+		if strings.HasPrefix(line, "+ ") {
+			include = true
+			line = strings.TrimSpace(line[2:])
+		} else if strings.HasPrefix(line, "- ") {
+			line = strings.TrimSpace(line[2:])
+		}
+
+		rule, err := newFilterRule(line, include)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+/*
+relPath 返回文件相对于列出目录的路径
+@root: 列出的目录
+@p: 文件路径
+*/
+func relPath(root, p string) string {
+	root = strings.Trim(root, "/")
+	p = strings.Trim(p, "/")
+	if root != "" && root != "." && strings.HasPrefix(p, root+"/") {
+		p = strings.TrimPrefix(p, root+"/")
+	}
+	return p
+}
+
+/*
+skipDir 遍历目录时判断是否跳过整个目录，仅处理隐藏目录和以/结尾的exclude规则
+@rel: 目录相对于列出目录的路径
+*/
+func (f *Filter) skipDir(rel string) bool {
+	if f == nil || rel == "" || rel == "." {
+		return false
+	}
+
+	if f.skipHidden && strings.HasPrefix(path.Base(rel), ".") {
+		return true
+	}
+
+	for _, rule := range f.rules {
+		if rule.dirOnly && rule.match(rel, true) {
+			return !rule.include
+		}
+	}
+	return false
+}
+
+/*
+match 判断文件是否需要传输
+@rel: 文件相对于列出目录的路径
+@file: 文件对象
+*/
+func (f *Filter) match(rel string, file *File) bool {
+	if f == nil {
+		return true
+	}
+
+	// 检查所有上级目录
+	for dir := path.Dir(rel); dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+		if f.skipDir(dir) {
+			return false
+		}
+	}
+
+	if f.skipHidden && strings.HasPrefix(path.Base(rel), ".") {
+		return false
+	}
+
+	if f.minSize > 0 && file.Size < f.minSize {
+		return false
+	}
+	if f.maxSize > 0 && file.Size > f.maxSize {
+		return false
+	}
+	if !f.modifiedAfter.IsZero() && file.ModTime.Before(f.modifiedAfter) {
+		return false
+	}
+	if !f.modifiedBefore.IsZero() && !file.ModTime.Before(f.modifiedBefore) {
+		return false
+	}
+
+	for _, rule := range f.rules {
+		if rule.match(rel, false) {
+			return rule.include
+		}
+	}
+	return true
+}
+
+/*
+apply 过滤列出的文件
+@root: 列出的目录
+@files: 客户端列出的所有文件
+*/
+func (f *Filter) apply(root *File, files FileList) FileList {
+	if f == nil {
+		return files
+	}
+
+	res := FileList{Files: []*File{}}
+	for _, file := range files.Files {
+		rel := relPath(root.Path, file.Path)
+		// 直接指定的文件不做过滤
+		if file.Path != root.Path && !f.match(rel, file) {
+			log.Debugf("skip %s", file.Path)
+			continue
+		}
+		res.Files = append(res.Files, file)
+		res.Total += file.Size
+	}
+	return res
+}
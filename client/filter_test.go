@@ -0,0 +1,93 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ygidtu/transfer/base"
+)
+
+func TestFilterMatch(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "rules.txt")
+	if err := os.WriteFile(rules, []byte("# comment\n- tmp/*.fq.gz\n+ *.fq.gz\n- *\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	small := &File{Size: 10, ModTime: now}
+	large := &File{Size: 10 * 1024 * 1024, ModTime: now}
+	old := &File{Size: 10, ModTime: now.AddDate(-1, 0, 0)}
+
+	tests := []struct {
+		name   string
+		option base.Options
+		rel    string
+		file   *File
+		expect bool
+	}{
+		{"no rules", base.Options{}, "a/b.txt", small, true},
+		{"exclude by name", base.Options{Exclude: []string{"*.log"}}, "a/b.log", small, false},
+		{"exclude not match", base.Options{Exclude: []string{"*.log"}}, "a/b.txt", small, true},
+		{"exclude directory", base.Options{Exclude: []string{"tmp/"}}, "a/tmp/b.txt", small, false},
+		{"directory rule not match file", base.Options{Exclude: []string{"tmp/"}}, "a/tmp", small, true},
+		{"anchored exclude", base.Options{Exclude: []string{"a/*.txt"}}, "a/b.txt", small, false},
+		{"anchored exclude not match sub directory", base.Options{Exclude: []string{"a/*.txt"}}, "a/c/b.txt", small, true},
+		{"double star", base.Options{Exclude: []string{"a/**/b.txt"}}, "a/c/d/b.txt", small, false},
+		{"character class", base.Options{Exclude: []string{"b[0-9].txt"}}, "b1.txt", small, false},
+		{"negative character class", base.Options{Exclude: []string{"b[!0-9].txt"}}, "b1.txt", small, true},
+		{"include only", base.Options{Include: []string{"*.fq.gz"}}, "a/b.fq.gz", small, true},
+		{"include implies exclude others", base.Options{Include: []string{"*.fq.gz"}}, "a/b.txt", small, false},
+		{"include before exclude", base.Options{Include: []string{"*.fq.gz"}, Exclude: []string{"*.gz"}}, "b.fq.gz", small, true},
+		{"include in excluded directory", base.Options{Include: []string{"*.fq.gz"}, Exclude: []string{"tmp/"}}, "tmp/b.fq.gz", small, false},
+		{"rules from file in order", base.Options{ExcludeFrom: rules}, "tmp/b.fq.gz", small, false},
+		{"include from file", base.Options{ExcludeFrom: rules}, "a/b.fq.gz", small, true},
+		{"exclude all from file", base.Options{ExcludeFrom: rules}, "a/b.txt", small, false},
+		{"hidden file", base.Options{Skip: true}, "a/.b.txt", small, false},
+		{"hidden directory", base.Options{Skip: true}, ".a/b.txt", small, false},
+		{"smaller than min size", base.Options{MinSize: "1M"}, "b.txt", small, false},
+		{"larger than min size", base.Options{MinSize: "1M"}, "b.txt", large, true},
+		{"larger than max size", base.Options{MaxSize: "1.5M"}, "b.txt", large, false},
+		{"modified after", base.Options{ModifiedAfter: now.AddDate(0, -1, 0).Format("2006-01-02")}, "b.txt", old, false},
+		{"modified before", base.Options{ModifiedBefore: now.AddDate(0, -1, 0).Format("2006-01-02")}, "b.txt", small, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := NewFilter(&test.option)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res := f.match(test.rel, test.file); res != test.expect {
+				t.Errorf("match(%s) = %v, expect %v", test.rel, res, test.expect)
+			}
+		})
+	}
+}
+
+func TestFilterSkipDir(t *testing.T) {
+	tests := []struct {
+		name   string
+		option base.Options
+		rel    string
+		expect bool
+	}{
+		{"no rules", base.Options{}, "a", false},
+		{"exclude directory", base.Options{Exclude: []string{"tmp/"}}, "a/tmp", true},
+		{"include not skip other directories", base.Options{Include: []string{"*.fq.gz"}}, "a", false},
+		{"hidden directory", base.Options{Skip: true}, "a/.git", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := NewFilter(&test.option)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res := f.skipDir(test.rel); res != test.expect {
+				t.Errorf("skipDir(%s) = %v, expect %v", test.rel, res, test.expect)
+			}
+		})
+	}
+}
@@ -1,37 +1,54 @@
 package client
 
 import (
-	"crypto/md5"
+	"errors"
 	"fmt"
 	"github.com/jlaffaye/ftp"
 	"github.com/ygidtu/transfer/base/fi"
 	"io"
 	"io/fs"
+	"net/textproto"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
 	"time"
 )
 
+// ftp连接使用tls加密的方式
+const (
+	FtpTlsImplicit = "implicit" // 连接后直接进行tls握手，ftps://
+	FtpTlsExplicit = "explicit" // 连接后通过AUTH TLS升级为tls，ftp://host/path?tls=explicit
+)
+
 // FtpClient 连接的配置
 type FtpClient struct {
 	Host   *Proxy
-	Client *ftp.ServerConn
+	TLS    string       // tls加密的方式，为空时不加密
+	pool   *ftpPool     // 已登录的ftp连接池，每个操作独占一个连接
+	hasher ftpHasher    // 通过单独的连接由服务器计算校验值
+	lock   sync.RWMutex // 重新连接时仅在替换连接池期间持有写锁
+	noMfmt sync.Once    // 服务器不支持MFMT时仅警告一次
 }
 
 /*
 NewFtp 新建新的ftp客户端
-@host: ftp的地址
+@host: ftp的地址，ftps://为隐式tls，ftp://的tls参数可以指定explicit或implicit
 */
 func NewFtp(host *Proxy) *FtpClient {
-	if host.Port == "" {
-		host.Port = "21"
+	cfg := &FtpClient{Host: host, TLS: host.URL.Query().Get("tls")}
+	if host.Scheme == "ftps" && cfg.TLS == "" {
+		cfg.TLS = FtpTlsImplicit
 	}
 
-	cfg := &FtpClient{Host: host}
+	if cfg.TLS != "" && cfg.TLS != FtpTlsImplicit && cfg.TLS != FtpTlsExplicit {
+		log.Fatalf("unsupported ftp tls mode: %s, should be implicit or explicit", cfg.TLS)
+	}
 
-	if err := cfg.connect(); err != nil {
-		log.Fatal(err)
+	if host.Port == "" {
+		host.Port = "21"
+		if cfg.TLS == FtpTlsImplicit {
+			host.Port = "990"
+		}
 	}
 	return cfg
 }
@@ -39,27 +56,70 @@ func NewFtp(host *Proxy) *FtpClient {
 // clientType 返回客户端类型
 func (_ *FtpClient) clientType() TransferClientType { return Ftp }
 
-// connect 连接至ftp服务器
+// connect 连接至ftp服务器，--n-jobs个线程各自使用独立的连接；重新连接时替换连接池后再关闭旧的连接池
 func (fc *FtpClient) connect() error {
-	c, err := ftp.Dial(fc.Host.Addr(), ftp.DialWithTimeout(5*time.Second))
+	pool, err := newFtpPool(fc.dial, opt.Concurrent)
 	if err != nil {
 		return err
 	}
 
+	fc.lock.Lock()
+	old := fc.pool
+	fc.pool = pool
+	fc.lock.Unlock()
+
+	if old != nil {
+		fc.hasher.close()
+		_ = old.shutdown()
+	}
+	return nil
+}
+
+// conns 返回当前的ftp连接池
+func (fc *FtpClient) conns() *ftpPool {
+	fc.lock.RLock()
+	defer fc.lock.RUnlock()
+	return fc.pool
+}
+
+// dial 建立新的ftp连接并登录
+func (fc *FtpClient) dial() (*ftp.ServerConn, error) {
+	options := []ftp.DialOption{ftp.DialWithTimeout(5 * time.Second)}
+	if fc.TLS != "" {
+		config, err := tlsConfig(fc.Host.Host)
+		if err != nil {
+			return nil, err
+		}
+		if fc.TLS == FtpTlsImplicit {
+			options = append(options, ftp.DialWithTLS(config))
+		} else {
+			options = append(options, ftp.DialWithExplicitTLS(config))
+		}
+	}
+
+	c, err := ftp.Dial(fc.Host.Addr(), options...)
+	if err != nil {
+		return nil, err
+	}
+
 	if fc.Host.Username != "" && fc.Host.Password != "" {
 		err = c.Login(fc.Host.Username, fc.Host.Password)
 		if err != nil {
-			return err
+			_ = c.Quit()
+			return nil, err
 		}
 	}
-
-	fc.Client = c
-	return nil
+	return c, nil
 }
 
 // close 退出ftp
 func (fc *FtpClient) close() error {
-	return fc.Client.Quit()
+	pool := fc.conns()
+	if pool == nil {
+		return nil
+	}
+	fc.hasher.close()
+	return pool.shutdown()
 }
 
 // listFiles 列出ftp上特定目录下的所有文件
@@ -80,66 +140,53 @@ func (fc *FtpClient) listFiles(file *File) (FileList, error) {
 		files.Total += f.Size
 	} else {
 		// walk a directory
-		walker := fc.Client.Walk(file.Path)
+		err = fc.conns().do(func(conn *ftp.ServerConn) error {
+			walker := conn.Walk(file.Path)
 
-		for walker.Next() {
-			e := walker.Stat()
-			if opt.Skip && e.Name != "." && e.Name != "./" && strings.HasPrefix(e.Name, ".") {
-				continue
-			}
-			if e.Type == ftp.EntryTypeFile {
-				files.Files = append(
-					files.Files,
-					&File{Path: e.Name, Size: int64(e.Size), IsFile: true, client: fc},
-				)
-				files.Total += int64(e.Size)
-			} else if e.Type == ftp.EntryTypeLink {
-				files.Files = append(
-					files.Files,
-					&File{Path: e.Name, Size: int64(e.Size), IsFile: true, client: fc},
-				)
-				files.Total += int64(e.Size)
+			for walker.Next() {
+				e := walker.Stat()
+				if e.Type == ftp.EntryTypeFolder {
+					if filter.skipDir(relPath(file.Path, walker.Path())) {
+						walker.SkipDir()
+					}
+				} else if e.Type == ftp.EntryTypeFile || e.Type == ftp.EntryTypeLink {
+					files.Files = append(
+						files.Files,
+						&File{Path: walker.Path(), Size: int64(e.Size), ModTime: e.Time, IsFile: true, client: fc},
+					)
+					files.Total += int64(e.Size)
+				}
 			}
-		}
-
-		if walker.Err() != nil {
-			return files, walker.Err()
-		}
+			return walker.Err()
+		})
 	}
-	return files, nil
+	return files, err
 }
 
 // exists check whether file or directory exists
 func (fc *FtpClient) exists(path string) bool {
 	_, err := fc.stat(path)
-	return !os.IsNotExist(err)
+	return err == nil
 }
 
-// newFile 新建新的ftp文件对象
+// newFile 新建新的ftp文件对象，文件不存在时返回空的非文件对象
 func (fc *FtpClient) newFile(path string) (*File, error) {
-	var err error
-	stat, err := fc.Client.List(path)
-
-	if len(stat) > 0 && err == nil {
-		for _, i := range stat {
-			if i.Name == filepath.Base(path) {
-				return &File{Path: path, Size: int64(i.Size), IsFile: i.Type == ftp.EntryTypeFile, client: fc}, nil
-			}
-		}
-	}
-
-	if path != "/" {
-		err = os.ErrNotExist
+	stat, err := fc.stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &File{Path: path, Size: 0, IsFile: false, client: fc}, nil
+	} else if err != nil {
+		return nil, err
 	}
-
-	return &File{Path: path, Size: 0, client: fc, IsFile: true}, err
+	return &File{Path: path, Size: stat.Size(), ModTime: stat.ModTime(), IsFile: !stat.IsDir(), client: fc}, nil
 }
 
 // mkdir as name says
 func (fc *FtpClient) mkdir(path string) error {
 	_, err := fc.stat(path)
 	if err != nil {
-		return fc.Client.MakeDir(path)
+		return fc.conns().do(func(conn *ftp.ServerConn) error {
+			return conn.MakeDir(path)
+		})
 	}
 	return nil
 }
@@ -159,7 +206,32 @@ func (fc *FtpClient) reader(path string, offset int64) (io.ReadCloser, error) {
 		return nil, os.ErrNotExist
 	}
 	log.Debugf("FTP retr %v from %v", path, offset)
-	return fc.Client.RetrFrom(path, uint64(offset))
+
+	pool := fc.conns()
+	conn, err := pool.acquire()
+	if err != nil {
+		return nil, err
+	}
+	r, err := conn.RetrFrom(path, uint64(offset))
+	if err != nil {
+		pool.release(conn, err)
+		return nil, err
+	}
+	return &ftpReader{Response: r, pool: pool, conn: conn}, nil
+}
+
+// ftpReader 读取文件时独占ftp连接，关闭时归还连接
+type ftpReader struct {
+	*ftp.Response
+	pool *ftpPool
+	conn *ftpConn
+	once sync.Once
+}
+
+func (r *ftpReader) Close() error {
+	err := r.Response.Close()
+	r.once.Do(func() { r.pool.release(r.conn, err) })
+	return err
 }
 
 /*
@@ -176,66 +248,123 @@ func (fc *FtpClient) writeAt(reader io.Reader, path string, trunc bool) error {
 			offset = int(stat.Size())
 		}
 	}
-	return fc.Client.StorFrom(path, reader, uint64(offset))
+	return fc.conns().do(func(conn *ftp.ServerConn) error {
+		return conn.StorFrom(path, reader, uint64(offset))
+	})
 }
 
 /*
-getMd5 获取服务器上小文件的完整md5和大文件的头尾md5
+getMd5 根据--checksum计算服务器上文件的校验值，服务器支持HASH、XMD5或XSHA256时由服务器计算，否则流式计算
 @file: 服务器上文件对象
 */
 func (fc *FtpClient) getMd5(file *File) error {
-	if stat, err := fc.stat(file.Path); !os.IsNotExist(err) {
-		var data []byte
-		r, err := fc.reader(file.Path, 0)
-		if err != nil {
-			return err
-		}
-		if stat.Size() < fileSizeLimit {
-			data, err = io.ReadAll(r)
-			if err := r.Close(); err != nil {
-				return err
-			}
+	stat, err := fc.stat(file.Path)
+	if err != nil {
+		return err
+	}
+
+	alg := checksumAlgorithm()
+	if res, err := fc.hasher.checksum(fc, file.Path, alg); err == nil {
+		file.Md5 = res
+		return nil
+	} else {
+		log.Debugf("failed to calculate %s of %s on server, fallback to streaming: %v", alg, file.Path, err)
+	}
+
+	file.Md5, err = streamChecksum(fc, file.Path, stat.Size(), alg)
+	return err
+}
+
+/*
+stat 获取服务器上特定文件信息，服务器支持MLST时直接获取，否则通过LIST查找
+@path: 文件路径
+*/
+func (fc *FtpClient) stat(path string) (fs.FileInfo, error) {
+	if path == "/" {
+		return fi.FtpFileInfo{Root: true}, nil
+	}
+
+	var entry *ftp.Entry
+	err := fc.conns().do(func(conn *ftp.ServerConn) (err error) {
+		// MLSD和MLST同时支持，此时LIST的结果中时间是精确的
+		if conn.IsTimePreciseInList() {
+			entry, err = conn.GetEntry(path)
 		} else {
-			data = make([]byte, capacity)
-			_, err = r.Read(data[:capacity/2])
-			if err != nil {
-				return err
-			}
-			if err := r.Close(); err != nil {
-				return err
-			}
-			r, err = fc.reader(file.Path, stat.Size()-capacity/2)
-			if err != nil {
-				return err
-			}
-			if err := r.Close(); err != nil {
-				return err
-			}
-		}
-		if err != nil {
-			return err
+			entry, err = listEntry(conn, path)
 		}
+		return err
+	})
 
-		file.Md5 = fmt.Sprintf("%x", md5.Sum(data))
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code == ftp.StatusFileUnavailable {
+		return nil, fmt.Errorf("%w: %s", os.ErrNotExist, path)
+	} else if err != nil {
+		return nil, err
 	}
-	return nil
+	return fi.FtpFileInfo{File: entry}, nil
 }
 
 /*
-stat 获取服务器上特定文件信息
+listEntry 通过LIST查找文件，文件夹需要从上级目录的列表中查找
+@conn: ftp连接
 @path: 文件路径
 */
-func (fc *FtpClient) stat(path string) (fs.FileInfo, error) {
-	stat, err := fc.Client.List(path)
-	if len(stat) > 0 && err == nil {
-		for _, i := range stat {
+func listEntry(conn *ftp.ServerConn, path string) (*ftp.Entry, error) {
+	for _, dir := range []string{path, filepath.Dir(path)} {
+		entries, err := conn.List(dir)
+		if err != nil {
+			continue
+		}
+		for _, i := range entries {
 			if i.Name == filepath.Base(path) {
-				return fi.FtpFileInfo{File: i}, nil
+				return i, nil
 			}
 		}
 	}
-	if path == "/" {
-		return fi.FtpFileInfo{Root: true}, nil
+	return nil, fmt.Errorf("%w: %s", os.ErrNotExist, path)
+}
+
+/*
+remove 删除服务器上的文件
+@path: 文件路径
+*/
+func (fc *FtpClient) remove(path string) error {
+	return fc.conns().do(func(conn *ftp.ServerConn) error {
+		return conn.Delete(path)
+	})
+}
+
+/*
+setStat 通过MFMT修改服务器上文件的修改时间，服务器不支持MFMT时仅警告一次；ftp协议无法修改文件权限
+@path: 文件路径
+@modTime: 修改时间，为空时不修改
+@mode: 文件权限，ftp上忽略
+*/
+func (fc *FtpClient) setStat(path string, modTime time.Time, mode os.FileMode) error {
+	if mode != 0 {
+		log.Debugf("ftp do not support change mode of %s", path)
 	}
-	return nil, os.ErrNotExist
+	if modTime.IsZero() {
+		return nil
+	}
+	return fc.conns().do(func(conn *ftp.ServerConn) error {
+		if !conn.IsSetTimeSupported() {
+			fc.noMfmt.Do(func() {
+				log.Warnf("ftp server do not support MFMT, the modification time of files will not be preserved")
+			})
+			return nil
+		}
+		return conn.SetTime(path, modTime)
+	})
+}
+
+/*
+rename 重命名服务器上的文件
+@src: 原路径
+@dst: 新路径
+*/
+func (fc *FtpClient) rename(src, dst string) error {
+	return fc.conns().do(func(conn *ftp.ServerConn) error {
+		return conn.Rename(src, dst)
+	})
 }
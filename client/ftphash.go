@@ -0,0 +1,219 @@
+package client
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ftpHashCommands 各校验算法在HASH命令中的名称，以及对应的旧式命令
+var ftpHashCommands = map[string][2]string{
+	ChecksumMd5:    {"MD5", "XMD5"},
+	ChecksumSha256: {"SHA-256", "XSHA256"},
+}
+
+// ftpHasher 通过单独的控制连接发送HASH、XMD5或XSHA256命令，由服务器计算校验值；
+// jlaffaye/ftp既不支持发送任意命令，也不提供FEAT的结果
+type ftpHasher struct {
+	conn     *textproto.Conn
+	features map[string]string // FEAT返回的命令及其参数
+	selected string            // 当前通过OPTS HASH选择的算法
+	disabled bool              // 无法连接或者服务器不支持计算校验值
+	lock     sync.Mutex
+}
+
+/*
+dial 建立控制连接并登录，tls的处理方式与数据传输的连接一致
+@fc: ftp客户端
+*/
+func (h *ftpHasher) dial(fc *FtpClient) error {
+	conn, err := net.DialTimeout("tcp", fc.Host.Addr(), 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	var config *tls.Config
+	if fc.TLS != "" {
+		if config, err = tlsConfig(fc.Host.Host); err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+	if fc.TLS == FtpTlsImplicit {
+		conn = tls.Client(conn, config)
+	}
+
+	tp := textproto.NewConn(conn)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		_ = tp.Close()
+		return err
+	}
+
+	if fc.TLS == FtpTlsExplicit {
+		if _, _, err := h.cmd(tp, 234, "AUTH TLS"); err != nil {
+			_ = tp.Close()
+			return err
+		}
+		tp = textproto.NewConn(tls.Client(conn, config))
+	}
+
+	if fc.Host.Username != "" && fc.Host.Password != "" {
+		code, _, err := h.cmd(tp, -1, "USER %s", fc.Host.Username)
+		if err == nil && code == 331 {
+			_, _, err = h.cmd(tp, 230, "PASS %s", fc.Host.Password)
+		} else if err == nil && code != 230 {
+			err = fmt.Errorf("unexpected response of USER: %d", code)
+		}
+		if err != nil {
+			_ = tp.Close()
+			return err
+		}
+	}
+
+	h.features = map[string]string{}
+	if code, msg, err := h.cmd(tp, -1, "FEAT"); err == nil && code == 211 {
+		for _, line := range strings.Split(msg, "\n") {
+			if !strings.HasPrefix(line, " ") {
+				continue
+			}
+			fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+			if len(fields) > 1 {
+				h.features[strings.ToUpper(fields[0])] = fields[1]
+			} else {
+				h.features[strings.ToUpper(fields[0])] = ""
+			}
+		}
+	}
+	h.conn = tp
+	h.selected = ""
+	return nil
+}
+
+// cmd 发送命令并读取指定状态码的回复
+func (h *ftpHasher) cmd(tp *textproto.Conn, expect int, format string, args ...any) (int, string, error) {
+	if _, err := tp.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return tp.ReadResponse(expect)
+}
+
+/*
+checksum 由服务器计算文件的校验值，优先使用HASH命令，其次使用XMD5或XSHA256
+@fc: ftp客户端
+@path: 文件路径
+@alg: 校验算法
+*/
+func (h *ftpHasher) checksum(fc *FtpClient, path, alg string) (string, error) {
+	names, ok := ftpHashCommands[alg]
+	if !ok {
+		return "", fmt.Errorf("ftp server do not calculate %s", alg)
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.disabled {
+		return "", fmt.Errorf("ftp server do not calculate checksum")
+	}
+	if h.conn == nil {
+		if err := h.dial(fc); err != nil {
+			h.disabled = true
+			return "", err
+		}
+		if _, ok := h.features["HASH"]; !ok && !h.supported("XMD5") && !h.supported("XSHA256") {
+			h.disabled = true
+			h.closeConn()
+			return "", fmt.Errorf("ftp server do not support HASH, XMD5 or XSHA256")
+		}
+	}
+
+	res, err := h.hash(path, names[0], names[1])
+	if err != nil {
+		if _, ok := err.(*textproto.Error); !ok {
+			// 连接已经断开，下次重新连接
+			_ = h.conn.Close()
+			h.conn = nil
+		}
+	}
+	return res, err
+}
+
+/*
+hash 根据FEAT的结果选择命令计算校验值
+@path: 文件路径
+@name: HASH命令中的算法名称
+@legacy: 旧式命令
+*/
+func (h *ftpHasher) hash(path, name, legacy string) (string, error) {
+	if algs, ok := h.features["HASH"]; ok && strings.Contains(strings.ToUpper(algs), name) {
+		if h.selected != name {
+			if _, _, err := h.cmd(h.conn, 200, "OPTS HASH %s", name); err != nil {
+				return "", err
+			}
+			h.selected = name
+		}
+
+		// 213 SHA-256 0-49 169cd22282da7f147cb491e559e9dd filename
+		_, msg, err := h.cmd(h.conn, 213, "HASH %s", path)
+		if err != nil {
+			return "", err
+		}
+		if fields := strings.Fields(msg); len(fields) >= 3 && isHex(fields[2]) {
+			return strings.ToLower(fields[2]), nil
+		}
+		return "", fmt.Errorf("unexpected response of HASH: %s", msg)
+	}
+
+	if h.supported(legacy) {
+		// 不同服务器的回复格式不同，可能只有校验值，也可能包含文件名
+		_, msg, err := h.cmd(h.conn, 2, "%s %s", legacy, path)
+		if err != nil {
+			return "", err
+		}
+		for _, field := range strings.Fields(msg) {
+			if isHex(field) {
+				return strings.ToLower(field), nil
+			}
+		}
+		return "", fmt.Errorf("unexpected response of %s: %s", legacy, msg)
+	}
+	return "", fmt.Errorf("ftp server do not support HASH %s or %s", name, legacy)
+}
+
+// supported 检查FEAT中是否包含命令
+func (h *ftpHasher) supported(command string) bool {
+	_, ok := h.features[command]
+	return ok
+}
+
+// close 关闭计算校验值的连接，重新连接后再次检测服务器是否支持
+func (h *ftpHasher) close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.closeConn()
+	h.disabled = false
+}
+
+// closeConn 退出并关闭控制连接
+func (h *ftpHasher) closeConn() {
+	if h.conn != nil {
+		_, _ = h.conn.Cmd("QUIT")
+		_ = h.conn.Close()
+		h.conn = nil
+	}
+}
+
+// isHex 检查字符串是否为md5或sha256的16进制校验值
+func isHex(value string) bool {
+	if len(value) != 32 && len(value) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"errors"
+	"github.com/jlaffaye/ftp"
+	"io"
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// ftpKeepAlive 空闲的ftp连接每隔一段时间发送NOOP，避免被服务器断开
+const ftpKeepAlive = 30 * time.Second
+
+// ftpConn 连接池中已登录的ftp连接
+type ftpConn struct {
+	*ftp.ServerConn
+	used time.Time // 最后一次使用的时间
+}
+
+// ftpPool ftp连接池，ftp的控制连接同一时间只能执行一个命令，每次操作时独占一个连接
+type ftpPool struct {
+	dial   func() (*ftp.ServerConn, error) // 建立连接并登录
+	idle   []*ftpConn                      // 空闲的连接
+	size   int                             // 连接数量的上限
+	limit  int                             // 新建连接失败后暂时使用的上限，有连接断开时恢复为size
+	open   int                             // 已经建立或正在建立的连接数量
+	closed bool                            // 连接池是否已经关闭
+	lock   sync.Mutex
+	cond   *sync.Cond // 有连接归还或断开时唤醒等待的操作
+	done   chan struct{}
+}
+
+/*
+newFtpPool 新建ftp连接池，并建立第一个连接以检查服务器和登录信息
+@dial: 建立连接并登录的函数
+@size: 连接数量的上限
+*/
+func newFtpPool(dial func() (*ftp.ServerConn, error), size int) (*ftpPool, error) {
+	if size < 1 {
+		size = 1
+	}
+	pool := &ftpPool{dial: dial, size: size, limit: size, done: make(chan struct{})}
+	pool.cond = sync.NewCond(&pool.lock)
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	pool.open = 1
+	pool.idle = append(pool.idle, &ftpConn{ServerConn: conn, used: time.Now()})
+
+	go pool.keepAlive()
+	return pool, nil
+}
+
+// acquire 占用一个空闲的连接，没有空闲连接时新建连接，达到上限后等待其他操作归还连接或断开连接
+func (pool *ftpPool) acquire() (*ftpConn, error) {
+	pool.lock.Lock()
+	for {
+		if pool.closed {
+			pool.lock.Unlock()
+			return nil, errors.New("ftp connection pool is closed")
+		}
+
+		if n := len(pool.idle); n > 0 {
+			conn := pool.idle[n-1]
+			pool.idle = pool.idle[:n-1]
+			pool.lock.Unlock()
+			return pool.check(conn)
+		}
+
+		if pool.open < pool.limit {
+			pool.open++
+			pool.lock.Unlock()
+
+			conn, err := pool.dial()
+			if err == nil {
+				return &ftpConn{ServerConn: conn, used: time.Now()}, nil
+			}
+
+			pool.lock.Lock()
+			pool.open--
+			if pool.open == 0 {
+				pool.lock.Unlock()
+				return nil, err
+			}
+			// 服务器可能限制了每个用户的连接数量，在已有连接断开前只使用已有的连接
+			log.Debugf("failed to open more ftp connection, use %d connections: %v", pool.open, err)
+			pool.limit = pool.open
+		}
+		pool.cond.Wait()
+	}
+}
+
+// check 检查空闲较久的连接是否已被服务器断开，断开的连接重新登录
+func (pool *ftpPool) check(conn *ftpConn) (*ftpConn, error) {
+	if time.Since(conn.used) < ftpKeepAlive {
+		return conn, nil
+	}
+	if err := conn.NoOp(); err == nil {
+		return conn, nil
+	}
+	return pool.relogin(conn)
+}
+
+// relogin 关闭已经断开的连接，并重新建立连接和登录
+func (pool *ftpPool) relogin(conn *ftpConn) (*ftpConn, error) {
+	_ = conn.Quit()
+	log.Debugf("ftp connection is closed by server, login again")
+
+	c, err := pool.dial()
+	if err != nil {
+		pool.drop()
+		return nil, err
+	}
+	return &ftpConn{ServerConn: c, used: time.Now()}, nil
+}
+
+// drop 减少已断开连接的计数，并唤醒等待的操作重新建立连接
+func (pool *ftpPool) drop() {
+	pool.lock.Lock()
+	pool.open--
+	pool.limit = pool.size
+	pool.lock.Unlock()
+	pool.cond.Broadcast()
+}
+
+/*
+release 归还连接，连接已经断开时直接关闭，等待的操作或下次使用时重新建立连接
+@conn: acquire占用的连接
+@err: 使用连接时的错误
+*/
+func (pool *ftpPool) release(conn *ftpConn, err error) {
+	if ftpBroken(err) {
+		_ = conn.Quit()
+		pool.drop()
+		return
+	}
+
+	pool.lock.Lock()
+	if pool.closed {
+		pool.open--
+		pool.lock.Unlock()
+		_ = conn.Quit()
+		return
+	}
+	conn.used = time.Now()
+	pool.idle = append(pool.idle, conn)
+	pool.lock.Unlock()
+	pool.cond.Signal()
+}
+
+/*
+do 占用一个连接执行操作，完成后归还连接
+@fn: 需要执行的操作
+*/
+func (pool *ftpPool) do(fn func(conn *ftp.ServerConn) error) error {
+	conn, err := pool.acquire()
+	if err != nil {
+		return err
+	}
+	err = fn(conn.ServerConn)
+	pool.release(conn, err)
+	return err
+}
+
+// keepAlive 定期向空闲的连接发送NOOP，断开的连接重新登录
+func (pool *ftpPool) keepAlive() {
+	ticker := time.NewTicker(ftpKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pool.done:
+			return
+		case <-ticker.C:
+		}
+
+		pool.lock.Lock()
+		idle := pool.idle
+		pool.idle = nil
+		pool.lock.Unlock()
+
+		for _, conn := range idle {
+			conn, err := pool.check(conn)
+			if err != nil {
+				log.Debugf("failed to keep ftp connection alive: %v", err)
+				continue
+			}
+			conn.used = time.Now()
+			pool.release(conn, nil)
+		}
+	}
+}
+
+// shutdown 关闭连接池中的所有空闲连接，正在使用的连接在归还时关闭
+func (pool *ftpPool) shutdown() error {
+	pool.lock.Lock()
+	if pool.closed {
+		pool.lock.Unlock()
+		return nil
+	}
+	pool.closed = true
+	close(pool.done)
+	idle := pool.idle
+	pool.idle = nil
+	pool.open -= len(idle)
+	pool.lock.Unlock()
+	pool.cond.Broadcast()
+
+	var err error
+	for _, conn := range idle {
+		if e := conn.Quit(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+/*
+ftpBroken 检查错误是否由连接断开导致，断开的连接不能再次使用
+@err: 操作连接时的错误
+*/
+func ftpBroken(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	var protoErr *textproto.Error
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.As(err, &netErr) {
+		return true
+	}
+	// 421表示服务器将关闭控制连接
+	return errors.As(err, &protoErr) && protoErr.Code == ftp.StatusNotAvailable
+}
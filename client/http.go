@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // HttpClient http客户端的配置，同时涵盖了服务端和客户端两种模式
@@ -45,9 +46,9 @@ func NewHTTPClient(host, proxy *Proxy) (*HttpClient, error) {
 	return client, nil
 }
 
-// URL 返回http监听的url地址
+// URL 返回http监听的url地址，不包含路径，服务器的API均注册在根路径下
 func (hc *HttpClient) URL() string {
-	return strings.TrimRight(hc.host.URL.String(), "/")
+	return fmt.Sprintf("%s://%s", hc.host.URL.Scheme, hc.host.URL.Host)
 }
 
 /*
@@ -97,7 +98,7 @@ func (hc *HttpClient) listFilesServer(w http.ResponseWriter, _ *http.Request) {
 		}
 
 		for _, f := range files.Files {
-			f.Path = strings.Replace(f.Path, hc.root.Path, "", 1)
+			f.Path = strings.TrimPrefix(f.Path, hc.root.Path)
 		}
 	}
 
@@ -197,8 +198,13 @@ func (hc *HttpClient) createDirServer(w http.ResponseWriter, req *http.Request)
 	}
 }
 
-// getMd5Server 在服务器端计算目标文件的md5并返回
+// getMd5Server 在服务器端根据alg参数计算目标文件的校验值并返回
 func (hc *HttpClient) getMd5Server(w http.ResponseWriter, req *http.Request) {
+	alg := req.URL.Query().Get("alg")
+	if alg == "" {
+		alg = ChecksumHeadTail
+	}
+
 	for k, v := range req.URL.Query() {
 		if k == "path" && len(v) > 0 {
 			path := filepath.Join(hc.root.Path, v[0])
@@ -206,24 +212,14 @@ func (hc *HttpClient) getMd5Server(w http.ResponseWriter, req *http.Request) {
 				path = v[0]
 			}
 
-			if _, err := os.Stat(path); os.IsNotExist(err) {
+			if stat, err := os.Stat(path); err != nil {
+				w.WriteHeader(http.StatusNotModified)
+				_, _ = io.WriteString(w, err.Error())
+			} else if res, err := streamChecksum(NewLocal(), path, stat.Size(), alg); err != nil {
 				w.WriteHeader(http.StatusNotModified)
 				_, _ = io.WriteString(w, err.Error())
-				return
 			} else {
-				f, err := NewFile(path, NewLocal())
-				if err != nil {
-					w.WriteHeader(http.StatusNotModified)
-					_, _ = io.WriteString(w, err.Error())
-					return
-				}
-				err = f.GetMd5()
-				if err != nil {
-					w.WriteHeader(http.StatusNotModified)
-					_, _ = io.WriteString(w, err.Error())
-					return
-				}
-				_, _ = io.WriteString(w, f.Md5)
+				_, _ = io.WriteString(w, res)
 			}
 			return
 		}
@@ -256,30 +252,135 @@ func (hc *HttpClient) statServer(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+/*
+serverPath 将请求中的路径转换为服务器上的本地路径，并确保其不会超出根目录；
+兼容旧版本的客户端，请求中的路径已经包含根目录时视为本地路径
+@path: 请求中的路径
+*/
+func (hc *HttpClient) serverPath(path string) (string, error) {
+	root := filepath.Clean(hc.root.Path)
+	if path == root || strings.HasPrefix(path, strings.TrimRight(root, "/")+"/") {
+		path = strings.TrimPrefix(path, root)
+	}
+	return confinePath(root, path)
+}
+
+// setStatServer 在服务器端修改文件的修改时间和权限
+func (hc *HttpClient) setStatServer(w http.ResponseWriter, req *http.Request) {
+	path, err := hc.serverPath(req.URL.Query().Get("path"))
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, err.Error())
+		return
+	}
+
+	var modTime time.Time
+	if value := req.URL.Query().Get("mtime"); value != "" {
+		if modTime, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, err.Error())
+			return
+		}
+	}
+
+	mode := uint64(0)
+	if value := req.URL.Query().Get("mode"); value != "" {
+		if mode, err = strconv.ParseUint(value, 8, 32); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, err.Error())
+			return
+		}
+	}
+
+	if err := NewLocal().setStat(path, modTime, os.FileMode(mode)); err != nil {
+		w.WriteHeader(http.StatusNotModified)
+		_, _ = io.WriteString(w, err.Error())
+		return
+	}
+	_, _ = io.WriteString(w, "done")
+}
+
+// renameServer 在服务器端重命名文件
+func (hc *HttpClient) renameServer(w http.ResponseWriter, req *http.Request) {
+	src, err := hc.serverPath(req.URL.Query().Get("src"))
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, err.Error())
+		return
+	}
+	dst, err := hc.serverPath(req.URL.Query().Get("dst"))
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, err.Error())
+		return
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		w.WriteHeader(http.StatusNotModified)
+		_, _ = io.WriteString(w, err.Error())
+		return
+	}
+	_, _ = io.WriteString(w, "done")
+}
+
+// deleteServer 在服务器端删除文件
+func (hc *HttpClient) deleteServer(w http.ResponseWriter, req *http.Request) {
+	for k, v := range req.URL.Query() {
+		if k == "path" && len(v) > 0 {
+			path, err := hc.serverPath(v[0])
+			if err != nil {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = io.WriteString(w, err.Error())
+			} else if stat, err := os.Stat(path); err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = io.WriteString(w, err.Error())
+			} else if stat.IsDir() {
+				w.WriteHeader(http.StatusNotModified)
+				_, _ = io.WriteString(w, fmt.Sprintf("%s is a directory", v[0]))
+			} else if err := os.Remove(path); err != nil {
+				w.WriteHeader(http.StatusNotModified)
+				_, _ = io.WriteString(w, err.Error())
+			} else {
+				log.Infof("Remove file %s", path)
+				_, _ = io.WriteString(w, "done")
+			}
+			return
+		}
+	}
+}
+
+// handler 注册服务器端的所有API及文件下载服务
+func (hc *HttpClient) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/list", hc.listFilesServer)
+	mux.HandleFunc("/post", hc.getFilesServer)
+	mux.HandleFunc("/create", hc.createDirServer)
+	mux.HandleFunc("/md5", hc.getMd5Server)
+	mux.HandleFunc("/stat", hc.statServer)
+	mux.HandleFunc("/delete", hc.deleteServer)
+	mux.HandleFunc("/chattr", hc.setStatServer)
+	mux.HandleFunc("/rename", hc.renameServer)
+
+	if !hc.root.IsFile {
+		mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir(hc.root.Path))))
+	} else {
+		mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir(filepath.Dir(hc.root.Path)))))
+	}
+	return mux
+}
+
 // startServer 启动服务器端
 func (hc *HttpClient) startServer() error {
 	log.Info("path: ", hc.root.Path)
 	log.Info("host: ", hc.host)
 
-	http.HandleFunc("/list", hc.listFilesServer)
-	http.HandleFunc("/post", hc.getFilesServer)
-	http.HandleFunc("/create", hc.createDirServer)
-	http.HandleFunc("/md5", hc.getMd5Server)
-	http.HandleFunc("/stat", hc.statServer)
-
 	if _, ok := os.Stat(opt.Source); os.IsNotExist(ok) {
 		if err := os.MkdirAll(opt.Source, os.ModePerm); err != nil {
 			log.Fatal(err)
 		}
 	}
 
-	if !hc.root.IsFile {
-		http.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir(hc.root.Path))))
-	} else {
-		http.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir(filepath.Dir(hc.root.Path)))))
-	}
-
-	return http.ListenAndServe(hc.host.Addr(), nil)
+	return http.ListenAndServe(hc.host.Addr(), hc.handler())
 }
 
 /*
@@ -323,7 +424,9 @@ func (hc *HttpClient) listFiles(file *File) (FileList, error) {
 
 	err = json.Unmarshal(content, &files)
 
+	// 服务器返回的是相对于根目录的路径，需要转换为与target一致的完整路径
 	for _, f := range files.Files {
+		f.Path = filepath.Join(hc.root.Path, f.Path)
 		f.client = hc
 	}
 	return files, err
@@ -338,7 +441,7 @@ func (hc *HttpClient) exists(path string) bool {
 		local := NewLocal()
 		return local.exists(path)
 	}
-	_, err := hc.newResp(fmt.Sprintf("%s/list?path=%s", hc.URL(), strings.TrimLeft(path, hc.root.Path)))
+	_, err := hc.stat(path)
 	return err == nil
 }
 
@@ -356,25 +459,13 @@ func (hc *HttpClient) newFile(path string) (*File, error) {
 		hc.root = f
 		return f, err
 	}
-	stat, err := os.Stat(path)
+	stat, err := hc.stat(path)
 	if err != nil {
 		return nil, err
 	}
 
-	fSize := int64(0)
-	u, err := hc.newResp(fmt.Sprintf("%s/list?path=%s", hc.URL(), strings.TrimLeft(path, hc.root.Path)))
-	if err == nil {
-		fSizeStr, err := io.ReadAll(u)
-		if err == nil {
-			fSizeInt, err := strconv.ParseInt(string(fSizeStr), 10, 64)
-			if err == nil {
-				fSize = fSizeInt
-			}
-		}
-	}
-
 	return &File{
-		Path: strings.TrimLeft(path, hc.root.Path), Size: fSize,
+		Path: path, Size: stat.Size(), ModTime: stat.ModTime(), Mode: stat.Mode(),
 		IsFile: !stat.IsDir(), client: hc,
 	}, nil
 }
@@ -384,7 +475,11 @@ mkdir 向服务器端请求在服务器上新建目录
 @path: 目标文件路径
 */
 func (hc *HttpClient) mkdir(path string) error {
-	_, err := hc.newResp(fmt.Sprintf("%s/create?path=%s", hc.URL(), strings.TrimLeft(path, hc.root.Path)))
+	remote, err := hc.remotePath(path)
+	if err != nil {
+		return err
+	}
+	_, err = hc.newResp(fmt.Sprintf("%s/create?path=%s", hc.URL(), url.QueryEscape(remote)))
 	return err
 }
 
@@ -393,15 +488,19 @@ mkParent 向服务器端请求新建文件的父目录
 @path: 目标文件路径
 */
 func (hc *HttpClient) mkParent(path string) error {
-	return hc.mkdir(filepath.Dir(strings.TrimLeft(path, hc.root.Path)))
+	return hc.mkdir(filepath.Dir(path))
 }
 
 /*
-getMd5 向服务器端请求特定文件的md5信息
+getMd5 向服务器端请求特定文件的校验值，由服务器端直接计算
 @file: 目标文件路径
 */
 func (hc *HttpClient) getMd5(file *File) error {
-	u, err := hc.newResp(fmt.Sprintf("%s/md5?path=%s", hc.URL(), strings.TrimLeft(file.Path, hc.root.Path)))
+	remote, err := hc.remotePath(file.Path)
+	if err != nil {
+		return err
+	}
+	u, err := hc.newResp(fmt.Sprintf("%s/md5?path=%s&alg=%s", hc.URL(), url.QueryEscape(remote), checksumAlgorithm()))
 	if err != nil {
 		return err
 	}
@@ -419,7 +518,11 @@ stat 向服务器端请求特定文件的文件信息
 @path: 目标文件路径
 */
 func (hc *HttpClient) stat(path string) (os.FileInfo, error) {
-	u, err := hc.newResp(fmt.Sprintf("%s/stat?path=%s", hc.URL(), strings.TrimLeft(path, hc.root.Path)))
+	remote, err := hc.remotePath(path)
+	if err != nil {
+		return nil, err
+	}
+	u, err := hc.newResp(fmt.Sprintf("%s/stat?path=%s", hc.URL(), url.QueryEscape(remote)))
 	if err != nil {
 		return nil, err
 	}
@@ -444,7 +547,15 @@ func (hc *HttpClient) reader(path string, offset int64) (io.ReadCloser, error) {
 		client.Transport = hc.transport
 	}
 
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", hc.URL(), strings.TrimLeft(path, hc.root.Path)), nil)
+	remote, err := hc.remotePath(path)
+	if err != nil {
+		return nil, err
+	}
+	// 服务器的根目录为单个文件时，通过其所在目录下载
+	if remote == "" {
+		remote = "/" + filepath.Base(path)
+	}
+	req, err := http.NewRequest(http.MethodGet, hc.URL()+(&url.URL{Path: remote}).EscapedPath(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -469,7 +580,11 @@ func (hc *HttpClient) writeAt(reader io.Reader, path string, trunc bool) error {
 		mode = "t"
 	}
 
-	u := fmt.Sprintf("%v/post?path=%v&mode=%s", hc.URL(), url.PathEscape(path), mode)
+	remote, err := hc.remotePath(path)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%v/post?path=%v&mode=%s", hc.URL(), url.QueryEscape(remote), mode)
 	req, err := http.NewRequest(http.MethodPost, u, reader)
 	if err != nil {
 		return err
@@ -482,3 +597,120 @@ func (hc *HttpClient) writeAt(reader io.Reader, path string, trunc bool) error {
 	_, err = client.Do(req)
 	return err
 }
+
+/*
+remotePath 将客户端的路径转换为请求中相对于服务器根目录的路径，不在根目录下的路径返回错误
+@path: 客户端的路径
+*/
+func (hc *HttpClient) remotePath(path string) (string, error) {
+	root := strings.TrimRight(hc.root.Path, "/")
+	if root == "" || path == root {
+		return strings.TrimPrefix(path, root), nil
+	}
+	if !strings.HasPrefix(path, root+"/") {
+		return "", fmt.Errorf("%s is out of %s", path, hc.root.Path)
+	}
+	return strings.TrimPrefix(path, root), nil
+}
+
+/*
+remove 向服务器端请求删除特定文件
+@path: 目标文件路径
+*/
+func (hc *HttpClient) remove(path string) error {
+	if hc.server {
+		local := NewLocal()
+		return local.remove(path)
+	}
+
+	remote, err := hc.remotePath(path)
+	if err != nil {
+		return err
+	}
+	u, err := hc.newResp(fmt.Sprintf("%s/delete?path=%s", hc.URL(), url.QueryEscape(remote)))
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(u)
+	if err != nil {
+		return err
+	}
+	if string(content) != "done" {
+		return fmt.Errorf("failed to remove %s: %s", path, content)
+	}
+	return nil
+}
+
+/*
+setStat 向服务器端请求修改特定文件的修改时间和权限
+@path: 目标文件路径
+@modTime: 修改时间，为空时不修改
+@mode: 文件权限，为0时不修改
+*/
+func (hc *HttpClient) setStat(path string, modTime time.Time, mode os.FileMode) error {
+	if hc.server {
+		return NewLocal().setStat(path, modTime, mode)
+	}
+
+	remote, err := hc.remotePath(path)
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("path", remote)
+	if !modTime.IsZero() {
+		query.Set("mtime", modTime.Format(time.RFC3339Nano))
+	}
+	if mode != 0 {
+		query.Set("mode", strconv.FormatUint(uint64(mode.Perm()), 8))
+	}
+
+	u, err := hc.newResp(fmt.Sprintf("%s/chattr?%s", hc.URL(), query.Encode()))
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(u)
+	if err != nil {
+		return err
+	}
+	if string(content) != "done" {
+		return fmt.Errorf("failed to set stat of %s: %s", path, content)
+	}
+	return nil
+}
+
+/*
+rename 向服务器端请求重命名特定文件
+@src: 原路径
+@dst: 新路径
+*/
+func (hc *HttpClient) rename(src, dst string) error {
+	if hc.server {
+		return NewLocal().rename(src, dst)
+	}
+
+	remoteSrc, err := hc.remotePath(src)
+	if err != nil {
+		return err
+	}
+	remoteDst, err := hc.remotePath(dst)
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("src", remoteSrc)
+	query.Set("dst", remoteDst)
+
+	u, err := hc.newResp(fmt.Sprintf("%s/rename?%s", hc.URL(), query.Encode()))
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(u)
+	if err != nil {
+		return err
+	}
+	if string(content) != "done" {
+		return fmt.Errorf("failed to rename %s: %s", src, content)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+newTestHttp 启动共享临时目录的http服务器，返回服务器的根目录和以根目录为路径的客户端
+@t: 当前测试
+*/
+func newTestHttp(t *testing.T) (string, *HttpClient) {
+	root := t.TempDir()
+	srv := httptest.NewServer((&HttpClient{server: true, root: &File{Path: root}}).handler())
+	t.Cleanup(srv.Close)
+
+	host, err := CreateProxy(srv.URL + root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewHTTPClient(host, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root, client
+}
+
+func TestHttpSync(t *testing.T) {
+	saved := *opt
+	t.Cleanup(func() { *opt = saved })
+	opt.Sync, opt.MaxDelete = true, -1
+
+	source := t.TempDir()
+	for _, path := range []string{"keep.txt", "sub/keep.txt"} {
+		if err := os.MkdirAll(filepath.Join(source, filepath.Dir(path)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(source, path), []byte("keep"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 目标目录外的文件不能被删除
+	root, client := newTestHttp(t)
+	for _, path := range []string{"dst/keep.txt", "dst/sub/keep.txt", "dst/orphan.txt", "dst/sub/orphan.txt", "other.txt"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(path)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, path), []byte("keep"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src, err := NewFile(source, NewLocal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := NewFile(filepath.Join(root, "dst"), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.IsFile {
+		t.Fatalf("%s is not a directory", target.Path)
+	}
+	files, err := src.Children()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 客户端的各个请求均以相对于根目录的路径访问服务器
+	dst := files.Files[0].GetTarget(src, target)
+	if dst.Size != 4 || !dst.Exists() {
+		t.Errorf("unexpected target %+v", dst)
+	}
+	if err := dst.GetMd5(); err != nil || dst.Md5 == "" {
+		t.Errorf("failed to get checksum of %s: %v", dst.Path, err)
+	}
+	if err := dst.WriteAt(strings.NewReader("-new"), false); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := dst.Reader(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil || !bytes.Equal(content, []byte("-new")) {
+		t.Errorf("read %q from %s, expect -new: %v", content, dst.Path, err)
+	}
+
+	transfer := &Transfer{concurrent: 1, source: src, target: target, report: newReport(src, target)}
+	if err := transfer.sync(files); err != nil {
+		t.Fatal(err)
+	}
+	for path, exists := range map[string]bool{
+		"dst/keep.txt": true, "dst/sub/keep.txt": true, "other.txt": true,
+		"dst/orphan.txt": false, "dst/sub/orphan.txt": false,
+	} {
+		if _, err := os.Stat(filepath.Join(root, path)); (err == nil) != exists {
+			t.Errorf("expect %s exists: %v, got %v", path, exists, err)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package client
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"github.com/ygidtu/transfer/base"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalState 文件在传输任务中的状态
+type JournalState string
+
+const (
+	JournalJob      JournalState = "job"      // 任务信息，记录源路径和目标路径
+	JournalListed   JournalState = "listed"   // 已列出，尚未传输
+	JournalProgress JournalState = "progress" // 正在传输，记录已写入的位置
+	JournalDone     JournalState = "done"     // 传输完成
+	JournalVerified JournalState = "verified" // 传输完成并通过校验
+	JournalFailed   JournalState = "failed"   // 传输失败
+)
+
+// journalRecord 任务日志中的单条记录
+type journalRecord struct {
+	State     JournalState `json:"state"`
+	Path      string       `json:"path,omitempty"`
+	Size      int64        `json:"size,omitempty"`
+	ModTime   time.Time    `json:"mtime,omitempty"`
+	Offset    int64        `json:"offset,omitempty"`
+	Chunked   bool         `json:"chunked,omitempty"` // 分块写入，offset之后的内容可能不连续
+	Checksum  string       `json:"checksum,omitempty"`
+	Algorithm string       `json:"alg,omitempty"`
+	Source    string       `json:"source,omitempty"`
+	Target    string       `json:"target,omitempty"`
+	Time      time.Time    `json:"time"`
+}
+
+// Journal 记录传输任务中每个文件状态的日志，以追加的方式写入，用于中断后跳过已完成的文件
+type Journal struct {
+	ID      string // 任务id
+	Source  string // 源路径
+	Target  string // 目标路径
+	path    string
+	writer  *os.File
+	records map[string]*journalRecord // 每个文件的最新状态
+	lock    sync.Mutex
+}
+
+var jobIdRegex = regexp.MustCompile(`[^\w.-]`)
+
+/*
+journalPath 返回任务日志的路径
+@id: 任务id
+*/
+func journalPath(id string) (string, error) {
+	root, err := base.CacheDir("journal")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, jobIdRegex.ReplaceAllString(id, "_")+".jsonl"), nil
+}
+
+/*
+stripUserinfo 去除链接中的用户名和密码，避免将密码写入任务日志
+@path: 本地路径或者链接
+*/
+func stripUserinfo(path string) string {
+	scheme, rest, ok := strings.Cut(path, "://")
+	if !ok {
+		return path
+	}
+	host := rest
+	if i := strings.Index(rest, "/"); i >= 0 {
+		host = rest[:i]
+	}
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		return scheme + "://" + rest[i+1:]
+	}
+	return path
+}
+
+/*
+journalID 根据去除了用户信息的源路径和目标路径生成默认的任务id
+@source: 源路径
+@target: 目标路径
+*/
+func journalID(source, target string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(stripUserinfo(source)+"|"+stripUserinfo(target))))[:12]
+}
+
+/*
+LoadJournal 读取已有的任务日志，不存在时返回空的日志
+@id: 任务id
+*/
+func LoadJournal(id string) (*Journal, error) {
+	path, err := journalPath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	journal := &Journal{ID: id, path: path, records: map[string]*journalRecord{}}
+	r, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return journal, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		record := &journalRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			// 中断时最后一行可能不完整
+			log.Debugf("skip broken journal record: %v", err)
+			continue
+		}
+
+		if record.State == JournalJob {
+			journal.Source = record.Source
+			journal.Target = record.Target
+		} else {
+			journal.records[record.Path] = record
+		}
+	}
+	return journal, scanner.Err()
+}
+
+/*
+open 压缩已有的日志，仅保留每个文件的最新状态，并以追加模式打开日志；源路径和目标路径中的用户名和密码不会写入日志
+@source: 源路径
+@target: 目标路径
+*/
+func (journal *Journal) open(source, target string) error {
+	source, target = stripUserinfo(source), stripUserinfo(target)
+	if journal.Source != "" && (journal.Source != source || journal.Target != target) {
+		return fmt.Errorf("job %s was created for %s -> %s", journal.ID, journal.Source, journal.Target)
+	}
+	journal.Source = source
+	journal.Target = target
+	if journal.writer != nil {
+		return nil
+	}
+
+	tmp := journal.path + ".tmp"
+	w, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(&journalRecord{State: JournalJob, Source: source, Target: target, Time: time.Now()}); err != nil {
+		_ = w.Close()
+		return err
+	}
+	for _, record := range journal.records {
+		if err := encoder.Encode(record); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, journal.path); err != nil {
+		return err
+	}
+
+	journal.writer, err = os.OpenFile(journal.path, os.O_WRONLY|os.O_APPEND, 0600)
+	return err
+}
+
+// close 关闭任务日志，关闭后可以通过open重新打开
+func (journal *Journal) close() error {
+	if journal == nil || journal.writer == nil {
+		return nil
+	}
+	err := journal.writer.Close()
+	journal.writer = nil
+	return err
+}
+
+/*
+record 追加记录文件的最新状态
+@state: 文件状态
+@src: 源文件
+@offset: 已写入的位置
+*/
+func (journal *Journal) record(state JournalState, src *File, offset int64) {
+	journal.write(&journalRecord{
+		State: state, Path: src.Path, Size: src.Size, ModTime: src.ModTime, Offset: offset, Time: time.Now(),
+	}, src)
+}
+
+/*
+chunk 记录分块传输的进度，offset之前的内容已经连续写入
+@src: 源文件
+@offset: 连续写入的位置
+*/
+func (journal *Journal) chunk(src *File, offset int64) {
+	journal.write(&journalRecord{
+		State: JournalProgress, Path: src.Path, Size: src.Size, ModTime: src.ModTime, Offset: offset, Chunked: true, Time: time.Now(),
+	}, src)
+}
+
+/*
+write 追加单条记录
+@record: 记录
+@src: 源文件
+*/
+func (journal *Journal) write(record *journalRecord, src *File) {
+	if journal == nil || journal.writer == nil {
+		return
+	}
+
+	state := record.State
+	if state == JournalVerified || state == JournalDone {
+		record.Checksum = src.Md5
+		if src.Md5 != "" {
+			record.Algorithm = checksumAlgorithm()
+		}
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Warnf("failed to encode journal of %s: %v", src.Path, err)
+		return
+	}
+
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	journal.records[src.Path] = record
+	if _, err := journal.writer.Write(append(data, '\n')); err != nil {
+		log.Warnf("failed to write journal %s: %v", journal.path, err)
+	}
+}
+
+/*
+get 返回源文件的最新记录，源文件大小或修改时间发生变化时视为无记录
+@src: 源文件
+*/
+func (journal *Journal) get(src *File) *journalRecord {
+	if journal == nil {
+		return nil
+	}
+
+	journal.lock.Lock()
+	record, ok := journal.records[src.Path]
+	journal.lock.Unlock()
+
+	if !ok || record.Size != src.Size || !record.ModTime.Equal(src.ModTime) {
+		return nil
+	}
+	return record
+}
+
+/*
+finished 检查源文件是否已经在之前的任务中完成传输
+@src: 源文件
+*/
+func (journal *Journal) finished(src *File) bool {
+	record := journal.get(src)
+	if record == nil {
+		return false
+	}
+	// 要求校验时，仅跳过已通过校验的文件
+	return record.State == JournalVerified || record.State == JournalDone && !opt.Verify
+}
+
+/*
+failed 记录传输失败的文件，保留之前记录的写入位置
+@src: 源文件
+*/
+func (journal *Journal) failed(src *File) {
+	record := &journalRecord{
+		State: JournalFailed, Path: src.Path, Size: src.Size, ModTime: src.ModTime, Time: time.Now(),
+	}
+	if last := journal.get(src); last != nil {
+		record.Offset = last.Offset
+		record.Chunked = last.Chunked
+	}
+	journal.write(record, src)
+}
+
+/*
+listed 记录所有列出的文件，已完成的文件保持原状态
+@files: 列出的文件
+*/
+func (journal *Journal) listed(files FileList) {
+	for _, f := range files.Files {
+		if journal.get(f) == nil {
+			journal.record(JournalListed, f, 0)
+		}
+	}
+}
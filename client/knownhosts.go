@@ -0,0 +1,136 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 主机秘钥的检查模式
+const (
+	HostKeyStrict    = "strict"     // 仅信任known_hosts中已有的主机
+	HostKeyAcceptNew = "accept-new" // 首次连接时信任并写入known_hosts，秘钥变化时拒绝连接
+	HostKeyOff       = "off"        // 不检查主机秘钥
+)
+
+// knownHostsLock 多个连接同时写入known_hosts时加锁
+var knownHostsLock sync.Mutex
+
+// knownHostsPath 返回--known-hosts指定的文件，默认为~/.ssh/known_hosts
+func knownHostsPath() string {
+	if opt.KnownHosts != "" {
+		return opt.KnownHosts
+	}
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+}
+
+/*
+hostKeyCallback 根据--host-key生成检查服务器秘钥的回调，以及该主机在known_hosts中已知的秘钥算法
+@addr: 服务器地址，host:port
+*/
+func hostKeyCallback(addr string) (ssh.HostKeyCallback, []string, error) {
+	mode := opt.HostKey
+	if mode == HostKeyOff {
+		log.Warnf("skip host key verification of %s", addr)
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	}
+
+	path := knownHostsPath()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if mode == HostKeyStrict {
+			return nil, nil, fmt.Errorf("known hosts file %s not exists", path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, nil, err
+		}
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	knownHostsLock.Lock()
+	callback, err := knownhosts.New(path)
+	knownHostsLock.Unlock()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read known hosts %s: %v", path, err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(keyErr.Want) > 0 {
+			want := keyErr.Want[0]
+			return fmt.Errorf("host key of %s has changed, offered %s key %s, but %s:%d expects %s; "+
+				"remove the line from known hosts if the change is expected",
+				hostname, key.Type(), fingerprint, want.Filename, want.Line, ssh.FingerprintSHA256(want.Key))
+		}
+
+		if mode != HostKeyAcceptNew {
+			return fmt.Errorf("host key of %s is unknown, offered %s key %s; "+
+				"add it to %s or use --host-key accept-new", hostname, key.Type(), fingerprint, path)
+		}
+
+		knownHostsLock.Lock()
+		defer knownHostsLock.Unlock()
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		hosts := []string{knownhosts.Normalize(hostname)}
+		if _, err := fmt.Fprintln(f, knownhosts.Line(hosts, key)); err != nil {
+			return err
+		}
+		log.Warnf("permanently added %s key %s of %s to %s", key.Type(), fingerprint, hostname, path)
+		return nil
+	}, knownHostAlgorithms(callback, addr), nil
+}
+
+/*
+knownHostAlgorithms 返回known_hosts中该主机已有秘钥的算法，使服务器优先提供已知的秘钥
+@callback: known_hosts的回调
+@addr: 服务器地址，host:port
+*/
+func knownHostAlgorithms(callback ssh.HostKeyCallback, addr string) []string {
+	// 用一个不可能存在的秘钥触发KeyError，从中获取已知的秘钥
+	var keyErr *knownhosts.KeyError
+	err := callback(addr, &net.TCPAddr{IP: net.IPv4zero}, dummyKey{})
+	if !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	seen := map[string]bool{}
+	for _, known := range keyErr.Want {
+		keyType := known.Key.Type()
+		types := []string{keyType}
+		if keyType == ssh.KeyAlgoRSA {
+			types = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+		}
+		for _, t := range types {
+			if !seen[t] {
+				seen[t] = true
+				algorithms = append(algorithms, t)
+			}
+		}
+	}
+	return algorithms
+}
+
+// dummyKey 仅用于查询known_hosts的空秘钥
+type dummyKey struct{}
+
+func (dummyKey) Type() string                            { return "dummy" }
+func (dummyKey) Marshal() []byte                         { return []byte("dummy") }
+func (dummyKey) Verify(_ []byte, _ *ssh.Signature) error { return errors.New("dummy key") }
@@ -1,12 +1,10 @@
 package client
 
 import (
-	"crypto/md5"
-	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
 )
 
 // LocalClient 本地文件的客户端，无需任何配置
@@ -29,46 +27,17 @@ func (l *LocalClient) connect() error { return nil }
 func (l *LocalClient) close() error { return nil }
 
 /*
-getMd5 计算本地小文件的完成md5，大文件的头尾md5
+getMd5 根据--checksum计算本地文件的校验值，默认为小文件的完整md5，大文件的头尾md5
 @file: 本地文件的路径
 */
 func (l *LocalClient) getMd5(file *File) error {
 	stat, err := os.Stat(file.Path)
-	if os.IsNotExist(err) {
-		return err
-	}
-	var data []byte
-	// 文件小于10M
-	f, err := os.Open(file.Path)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	if stat.Size() < fileSizeLimit {
-		data, err = io.ReadAll(f)
-		if err != nil {
-			return err
-		}
-	} else {
-		// 文件大于10M，则从头尾各取一部分机选MD5
-		data = make([]byte, capacity)
-		f, err := os.Open(file.Path)
 
-		_, err = f.Read(data[:capacity/2])
-		if err != nil {
-			return err
-		}
-		_, err = f.ReadAt(data[capacity/2:], stat.Size()-capacity/2)
-		if err != nil {
-			return err
-		}
-	}
-
-	if data != nil {
-		file.Md5 = fmt.Sprintf("%x", md5.Sum(data))
-	}
-	return nil
+	file.Md5, err = streamChecksum(l, file.Path, stat.Size(), checksumAlgorithm())
+	return err
 }
 
 /*
@@ -97,7 +66,7 @@ newFile 生成本地文件对象
 func (l *LocalClient) newFile(path string) (*File, error) {
 	stat, err := os.Stat(path)
 	if !os.IsNotExist(err) {
-		return &File{Path: path, Size: stat.Size(), IsFile: !stat.IsDir(), client: l}, nil
+		return &File{Path: path, Size: stat.Size(), ModTime: stat.ModTime(), Mode: stat.Mode(), IsFile: !stat.IsDir(), client: l}, nil
 	}
 	return &File{Path: path, Size: 0, IsFile: false, client: l}, nil
 }
@@ -158,6 +127,19 @@ func (l *LocalClient) writeAt(reader io.Reader, path string, trunc bool) error {
 	return err
 }
 
+/*
+writerAt 打开本地文件用于分块位置写入
+@path: 本地文件的路径
+@trunc: 是否清空已有的内容
+*/
+func (l *LocalClient) writerAt(path string, trunc bool) (writerAtCloser, error) {
+	writerCode := os.O_CREATE | os.O_WRONLY
+	if trunc {
+		writerCode |= os.O_TRUNC
+	}
+	return os.OpenFile(path, writerCode, os.ModePerm)
+}
+
 /*
 stat 返回本地文件的文件信息，直接调用os.Stat
 @path: 本地文件的路径
@@ -179,20 +161,17 @@ func (l *LocalClient) listFiles(file *File) (FileList, error) {
 		files.Total += file.Size
 	} else if file.Path != "" {
 		err = filepath.Walk(file.Path, func(p string, info os.FileInfo, err error) error {
-			if opt.Skip && info.Name() != "./" && info.Name() != "." {
-				if strings.HasPrefix(info.Name(), ".") {
-					if info.IsDir() {
-						return filepath.SkipDir
-					}
-					return nil
-				}
+			if info != nil && info.IsDir() && filter.skipDir(relPath(file.Path, p)) {
+				return filepath.SkipDir
 			}
 			if info != nil && !info.IsDir() {
 				files.Files = append(files.Files, &File{
-					Path:   p,
-					Size:   info.Size(),
-					IsFile: !info.IsDir(),
-					client: l,
+					Path:    p,
+					Size:    info.Size(),
+					ModTime: info.ModTime(),
+					Mode:    info.Mode(),
+					IsFile:  !info.IsDir(),
+					client:  l,
 				})
 
 				files.Total += info.Size()
@@ -203,3 +182,38 @@ func (l *LocalClient) listFiles(file *File) (FileList, error) {
 
 	return files, err
 }
+
+/*
+remove 删除本地文件
+@path: 本地文件的路径
+*/
+func (l *LocalClient) remove(path string) error {
+	return os.Remove(path)
+}
+
+/*
+setStat 修改本地文件的修改时间和权限
+@path: 本地文件的路径
+@modTime: 修改时间，为空时不修改
+@mode: 文件权限，为0时不修改
+*/
+func (l *LocalClient) setStat(path string, modTime time.Time, mode os.FileMode) error {
+	if mode != 0 {
+		if err := os.Chmod(path, mode.Perm()); err != nil {
+			return err
+		}
+	}
+	if !modTime.IsZero() {
+		return os.Chtimes(path, modTime, modTime)
+	}
+	return nil
+}
+
+/*
+rename 重命名本地文件
+@src: 原路径
+@dst: 新路径
+*/
+func (l *LocalClient) rename(src, dst string) error {
+	return os.Rename(src, dst)
+}
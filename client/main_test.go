@@ -0,0 +1,141 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ygidtu/transfer/base"
+	"go.uber.org/zap"
+)
+
+// TestMain 测试使用临时的用户目录，避免读写真实的~/.aws和缓存目录
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "transfer-test")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	for _, key := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE",
+		"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ENDPOINT_URL", "SSH_AUTH_SOCK",
+	} {
+		_ = os.Unsetenv(key)
+	}
+	// aws sdk在初始化时已经确定了默认的配置文件路径，需要通过环境变量指定
+	_ = os.Setenv("HOME", home)
+	_ = os.Setenv("AWS_CONFIG_FILE", filepath.Join(home, ".aws", "config"))
+	_ = os.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, ".aws", "credentials"))
+	_ = os.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+
+	log = zap.NewNop().Sugar()
+	base.SugaredLog = log
+	opt = &base.Options{Concurrent: 2, PartSize: 5, Checksum: "md5", HostKey: "accept-new"}
+
+	code := m.Run()
+	_ = os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// testS3 测试用的s3服务器及连接至该服务器的客户端
+type testS3 struct {
+	root     string // 服务器的根目录，其中的bucket目录为客户端使用的bucket
+	url      string // 服务器的地址
+	client   *AwsS3Client
+	lock     sync.Mutex
+	requests []string // 服务器收到的请求，method及uri
+}
+
+/*
+newTestS3 在临时目录中启动s3服务器，并通过名为test的profile连接
+@t: 当前测试
+*/
+func newTestS3(t *testing.T) *testS3 {
+	ts := &testS3{root: t.TempDir()}
+	if err := os.Mkdir(filepath.Join(ts.root, "bucket"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(ts.root, s3UploadsDir), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := CreateProxy("s3://AKTEST:secret@127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewS3Server(host, ts.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.lock.Lock()
+		ts.requests = append(ts.requests, r.Method+" "+r.URL.RequestURI())
+		ts.lock.Unlock()
+		server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	ts.url = srv.URL
+
+	home, _ := os.UserHomeDir()
+	if err := os.MkdirAll(filepath.Join(home, ".aws"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	credentials := fmt.Sprintf("[test]\naws_access_key_id = AKTEST\naws_secret_access_key = secret\nendpoint_url = %s\n", srv.URL)
+	if err := os.WriteFile(filepath.Join(home, ".aws", "credentials"), []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".aws", "config"), []byte("[profile test]\nregion = us-east-1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := CreateProxy("s3://test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.client, err = NewS3Client(target, nil, "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.client.connect(); err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+// received 返回并清空服务器收到的请求
+func (ts *testS3) received() []string {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	requests := ts.requests
+	ts.requests = nil
+	return requests
+}
+
+/*
+serveTest 在随机端口上监听，每个连接由单独的goroutine处理，返回监听的地址
+@t: 当前测试
+@serve: 处理连接的函数
+*/
+func serveTest(t *testing.T, serve func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
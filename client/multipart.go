@@ -0,0 +1,487 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/ygidtu/transfer/base"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minPartSize  = int64(5 * 1024 * 1024)        // aws要求除最后一个分片外，分片不得小于5M
+	maxParts     = int64(10000)                  // aws单个文件最多10000个分片
+	maxCopySize  = int64(5 * 1024 * 1024 * 1024) // aws的CopyObject最多复制5G的文件
+	copyPartSize = int64(512 * 1024 * 1024)      // 服务器端分片复制时的分片大小
+)
+
+// multipartState 记录分片上传的进度，用于中断后续传
+type multipartState struct {
+	Profile  string           `json:"profile"`
+	Endpoint string           `json:"endpoint"`
+	Bucket   string           `json:"bucket"`
+	Key      string           `json:"key"`
+	UploadId string           `json:"uploadId"`
+	Source   string           `json:"source"`
+	Size     int64            `json:"size"`
+	ModTime  time.Time        `json:"modTime"` // 源文件的修改时间
+	Head     string           `json:"head"`    // 源文件第一个分片的md5，源文件被改写为相同大小时用以发现变化
+	PartSize int64            `json:"partSize"`
+	Parts    map[int32]string `json:"parts"` // 已完成的分片编号及其ETag
+
+	path string
+	lock sync.Mutex
+}
+
+/*
+loadMultipartState 读取缓存目录中特定文件的分片上传进度，不存在时返回新的进度对象
+@profile: aws profile
+@endpoint: 服务器地址及region
+@bucket: aws bucket
+@key: 目标文件路径
+*/
+func loadMultipartState(profile, endpoint, bucket, key string) (*multipartState, error) {
+	root, err := base.CacheDir("multipart")
+	if err != nil {
+		return nil, err
+	}
+
+	// 不同服务器或profile下的同名bucket是不同的目标
+	id := md5.Sum([]byte(strings.Join([]string{profile, endpoint, bucket, key}, "|")))
+	state := &multipartState{
+		Profile: profile, Endpoint: endpoint, Bucket: bucket, Key: key, Parts: map[int32]string{},
+		path: filepath.Join(root, fmt.Sprintf("%x.json", id)),
+	}
+
+	data, err := os.ReadFile(state.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		log.Warnf("ignore broken multipart state %s: %v", state.path, err)
+		state.UploadId = ""
+	}
+	if state.Parts == nil {
+		state.Parts = map[int32]string{}
+	}
+	return state, nil
+}
+
+// save 将分片上传进度写入缓存目录
+func (state *multipartState) save() error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := state.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, state.path)
+}
+
+// remove 删除缓存的分片上传进度
+func (state *multipartState) remove() error {
+	if err := os.Remove(state.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+/*
+complete 记录已完成的分片
+@number: 分片编号
+@etag: 分片的ETag
+*/
+func (state *multipartState) complete(number int32, etag string) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.Parts[number] = etag
+	return state.save()
+}
+
+/*
+partRange 返回特定分片在源文件中的起始位置和大小
+@number: 分片编号，从1开始
+*/
+func (state *multipartState) partRange(number int32) (int64, int64) {
+	offset := int64(number-1) * state.PartSize
+	size := state.PartSize
+	if offset+size > state.Size {
+		size = state.Size - offset
+	}
+	return offset, size
+}
+
+// numParts 返回分片总数
+func (state *multipartState) numParts() int32 {
+	return int32((state.Size + state.PartSize - 1) / state.PartSize)
+}
+
+// uploaded 返回已完成上传的数据量
+func (state *multipartState) uploaded() int64 {
+	total := int64(0)
+	for number := range state.Parts {
+		_, size := state.partRange(number)
+		total += size
+	}
+	return total
+}
+
+// completedParts 返回按编号排序的已完成分片
+func (state *multipartState) completedParts() []types.CompletedPart {
+	var parts []types.CompletedPart
+	for number, etag := range state.Parts {
+		parts = append(parts, types.CompletedPart{PartNumber: aws.Int32(number), ETag: aws.String(etag)})
+	}
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+	return parts
+}
+
+/*
+partSize 根据文件大小和--part-size计算分片大小，保证不超过aws的分片数量上限
+@size: 文件大小
+*/
+func partSize(size int64) int64 {
+	part := int64(opt.PartSize) * 1024 * 1024
+	if part < minPartSize {
+		part = minPartSize
+	}
+
+	for size > part*maxParts {
+		part *= 2
+	}
+	return part
+}
+
+/*
+headChecksum 计算源文件开头部分的md5
+@src: 源文件
+@size: 计算的数据量
+*/
+func headChecksum(src *File, size int64) (string, error) {
+	r, err := src.Reader(0)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := md5.New()
+	if _, err := io.CopyN(h, r, size); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+/*
+multipartState 读取并校验已有的分片上传进度，与服务器上的分片信息对齐
+@src: 源文件
+@path: 目标文件路径
+*/
+func (asc *AwsS3Client) multipartState(src *File, path string) (*multipartState, error) {
+	state, err := loadMultipartState(asc.Host.Host, asc.endpoint, asc.Bucket, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.UploadId == "" {
+		return state, nil
+	}
+
+	// 源文件发生变化，放弃之前的上传；续传时沿用之前的分片大小
+	changed := state.Source != src.Path || state.Size != src.Size || !state.ModTime.Equal(src.ModTime) || state.PartSize < minPartSize
+	if !changed {
+		_, size := state.partRange(1)
+		head, err := headChecksum(src, size)
+		if err != nil {
+			return nil, err
+		}
+		changed = head != state.Head
+	}
+	if changed {
+		uploadId := state.UploadId
+		state.UploadId = ""
+		state.Parts = map[int32]string{}
+		if opt.DryRun {
+			return state, nil
+		}
+
+		log.Infof("source of %s changed, abort previous multipart upload", path)
+		_, _ = asc.client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+			Bucket: aws.String(asc.Bucket), Key: aws.String(path), UploadId: aws.String(uploadId),
+		})
+		return state, state.remove()
+	}
+
+	// 以服务器上实际完成的分片为准
+	parts := map[int32]string{}
+	paginator := s3.NewListPartsPaginator(asc.client, &s3.ListPartsInput{
+		Bucket: aws.String(asc.Bucket), Key: aws.String(path), UploadId: aws.String(state.UploadId),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			var noSuchUpload *types.NoSuchUpload
+			if errors.As(err, &noSuchUpload) {
+				log.Infof("multipart upload of %s expired, restart from beginning", path)
+				state.UploadId = ""
+				state.Parts = map[int32]string{}
+				if opt.DryRun {
+					return state, nil
+				}
+				return state, state.remove()
+			}
+			return nil, err
+		}
+
+		for _, part := range output.Parts {
+			parts[*part.PartNumber] = *part.ETag
+		}
+	}
+	state.Parts = parts
+	return state, nil
+}
+
+/*
+uploaded 返回目标文件已经通过分片上传的数据量
+@src: 源文件
+@path: 目标文件路径
+*/
+func (asc *AwsS3Client) uploaded(src *File, path string) int64 {
+	if src.Size < partSize(src.Size) {
+		return 0
+	}
+
+	state, err := asc.multipartState(src, path)
+	if err != nil {
+		log.Debugf("failed to load multipart state of %s: %v", path, err)
+		return 0
+	}
+	return state.uploaded()
+}
+
+/*
+uploadPart 从源文件读取特定分片并上传，本地文件直接读取对应的区间，其他来源的文件先读入缓冲区
+@src: 源文件
+@state: 分片上传进度
+@number: 分片编号
+@buf: 读取非本地文件时使用的缓冲区，由上传的goroutine复用
+*/
+func (asc *AwsS3Client) uploadPart(src *File, state *multipartState, number int32, buf []byte) (int64, error) {
+	offset, size := state.partRange(number)
+
+	var body io.ReadSeeker
+	if src.Source() == Local {
+		r, err := os.Open(src.Path)
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+		body = io.NewSectionReader(r, offset, size)
+	} else {
+		r, err := src.Reader(offset)
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+
+		if _, err := io.ReadFull(r, buf[:size]); err != nil {
+			return 0, fmt.Errorf("failed to read part %d of %s: %v", number, src.Path, err)
+		}
+		body = bytes.NewReader(buf[:size])
+	}
+
+	output, err := asc.client.UploadPart(context.TODO(), &s3.UploadPartInput{
+		Bucket:        aws.String(asc.Bucket),
+		Key:           aws.String(state.Key),
+		UploadId:      aws.String(state.UploadId),
+		PartNumber:    aws.Int32(number),
+		ContentLength: aws.Int64(size),
+		Body:          body,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload part %d of %s: %v", number, state.Key, err)
+	}
+	return size, state.complete(number, *output.ETag)
+}
+
+/*
+multipartUpload 分片并行上传文件，已完成的分片记录在缓存目录中，中断后可续传
+@src: 源文件
+@path: 目标文件路径
+@progress: 每完成一部分数据时的回调
+*/
+func (asc *AwsS3Client) multipartUpload(src *File, path string, progress func(int64)) error {
+	state, err := asc.multipartState(src, path)
+	if err != nil {
+		return err
+	}
+
+	if state.UploadId == "" {
+		state.Source = src.Path
+		state.Size = src.Size
+		state.ModTime = src.ModTime
+		state.PartSize = partSize(src.Size)
+		_, size := state.partRange(1)
+		if state.Head, err = headChecksum(src, size); err != nil {
+			return err
+		}
+
+		output, err := asc.client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(asc.Bucket), Key: aws.String(path), Metadata: asc.metadata(preserveStat(src)),
+		})
+		if err != nil {
+			return err
+		}
+		state.UploadId = *output.UploadId
+		if err := state.save(); err != nil {
+			return err
+		}
+	} else {
+		log.Infof("resume multipart upload of %s from %d bytes", path, state.uploaded())
+	}
+	progress(state.uploaded())
+
+	var pending []int32
+	for number := int32(1); number <= state.numParts(); number++ {
+		if _, ok := state.Parts[number]; !ok {
+			pending = append(pending, number)
+		}
+	}
+
+	tasks := make(chan int32)
+	var errs []error
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < opt.Concurrent; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// 每个goroutine只分配一个分片大小的缓冲区，内存占用不超过--n-jobs个分片
+			var buf []byte
+			if src.Source() != Local {
+				buf = make([]byte, state.PartSize)
+			}
+			for number := range tasks {
+				size, err := asc.uploadPart(src, state, number, buf)
+				if err != nil {
+					lock.Lock()
+					errs = append(errs, err)
+					lock.Unlock()
+					continue
+				}
+				progress(size)
+			}
+		}()
+	}
+
+	for _, number := range pending {
+		tasks <- number
+	}
+	close(tasks)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	_, err = asc.client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(asc.Bucket),
+		Key:             aws.String(path),
+		UploadId:        aws.String(state.UploadId),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: state.completedParts()},
+	})
+	if err != nil {
+		return err
+	}
+	return state.remove()
+}
+
+/*
+multipartCopy 通过UploadPartCopy在服务器端分片并行复制大文件
+@source: 源文件，bucket/key格式
+@size: 源文件大小
+@path: 目标文件路径
+*/
+func (asc *AwsS3Client) multipartCopy(source string, size int64, path string) error {
+	output, err := asc.client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(asc.Bucket), Key: aws.String(path),
+	})
+	if err != nil {
+		return err
+	}
+	state := &multipartState{
+		Bucket: asc.Bucket, Key: path, UploadId: *output.UploadId,
+		Size: size, PartSize: copyPartSize, Parts: map[int32]string{},
+	}
+
+	tasks := make(chan int32)
+	var errs []error
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < opt.Concurrent; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range tasks {
+				offset, size := state.partRange(number)
+				res, err := asc.client.UploadPartCopy(context.TODO(), &s3.UploadPartCopyInput{
+					Bucket:          aws.String(asc.Bucket),
+					Key:             aws.String(path),
+					UploadId:        aws.String(state.UploadId),
+					PartNumber:      aws.Int32(number),
+					CopySource:      aws.String(source),
+					CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)),
+				})
+
+				lock.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					state.Parts[number] = *res.CopyPartResult.ETag
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	for number := int32(1); number <= state.numParts(); number++ {
+		tasks <- number
+	}
+	close(tasks)
+	wg.Wait()
+
+	if len(errs) == 0 {
+		_, err = asc.client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(asc.Bucket),
+			Key:             aws.String(path),
+			UploadId:        aws.String(state.UploadId),
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: state.completedParts()},
+		})
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+
+	_, _ = asc.client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+		Bucket: aws.String(asc.Bucket), Key: aws.String(path), UploadId: aws.String(state.UploadId),
+	})
+	return errs[0]
+}
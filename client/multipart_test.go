@@ -0,0 +1,200 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestMultipartStateKey(t *testing.T) {
+	first, err := loadMultipartState("test", "http://127.0.0.1:9000|us-east-1", "bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range [][2]string{
+		{"other", "http://127.0.0.1:9000|us-east-1"},
+		{"test", "http://127.0.0.1:9001|us-east-1"},
+	} {
+		state, err := loadMultipartState(other[0], other[1], "bucket", "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.path == first.path {
+			t.Errorf("profile %s and endpoint %s share the multipart state with %s", other[0], other[1], first.path)
+		}
+	}
+
+	// 同一region下不同账号的客户端不是同一个服务器
+	if endpoint := newTestS3(t).client.endpoint; !strings.HasSuffix(endpoint, "|AKTEST") {
+		t.Errorf("endpoint %s does not contain the access key", endpoint)
+	}
+}
+
+/*
+interruptedUpload 模拟中断的分片上传：只完成了第一个分片，返回保存的上传进度
+@t: 当前测试
+@ts: 测试用的s3服务器
+@src: 源文件
+@key: 目标文件路径
+*/
+func interruptedUpload(t *testing.T, ts *testS3, src *File, key string) *multipartState {
+	state, err := ts.client.multipartState(src, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := ts.client.client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+		Bucket: aws.String("bucket"), Key: aws.String(key),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.UploadId, state.Source, state.Size, state.ModTime, state.PartSize = *output.UploadId, src.Path, src.Size, src.ModTime, partSize(src.Size)
+	if state.Head, err = headChecksum(src, state.PartSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.client.uploadPart(src, state, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestMultipartResume(t *testing.T) {
+	ts := newTestS3(t)
+
+	data := make([]byte, 2*minPartSize+1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	path := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewFile(path, NewLocal())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := interruptedUpload(t, ts, src, "big.bin")
+	if state.numParts() != 3 {
+		t.Fatalf("expect 3 parts, got %d", state.numParts())
+	}
+
+	saved, err := loadMultipartState("test", ts.client.endpoint, "bucket", "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.UploadId != state.UploadId || saved.Parts[1] == "" || saved.Parts[1] != state.Parts[1] {
+		t.Fatalf("upload id and etag are not saved: %+v", saved)
+	}
+	if uploaded := ts.client.uploaded(src, "big.bin"); uploaded != minPartSize {
+		t.Errorf("expect %d bytes uploaded, got %d", minPartSize, uploaded)
+	}
+
+	ts.received()
+	var progress []int64
+	var lock sync.Mutex
+	if err := ts.client.multipartUpload(src, "big.bin", func(n int64) {
+		lock.Lock()
+		defer lock.Unlock()
+		progress = append(progress, n)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) == 0 || progress[0] != minPartSize {
+		t.Errorf("expect resuming from %d bytes, got %v", minPartSize, progress)
+	}
+
+	parts := map[string]bool{}
+	for _, request := range ts.received() {
+		if strings.HasPrefix(request, "POST ") && strings.Contains(request, "?uploads") {
+			t.Errorf("a new multipart upload is created: %s", request)
+		}
+		if strings.HasPrefix(request, "PUT ") && strings.Contains(request, "partNumber=") {
+			if !strings.Contains(request, "uploadId="+state.UploadId) {
+				t.Errorf("part is uploaded to another upload: %s", request)
+			}
+			parts[request[strings.Index(request, "partNumber="):][:len("partNumber=1")]] = true
+		}
+	}
+	if parts["partNumber=1"] || !parts["partNumber=2"] || !parts["partNumber=3"] {
+		t.Errorf("expect only part 2 and 3 are uploaded, got %v", parts)
+	}
+
+	content, err := os.ReadFile(filepath.Join(ts.root, "bucket", "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, data) {
+		t.Errorf("uploaded file is different from source")
+	}
+
+	if saved, err := loadMultipartState("test", ts.client.endpoint, "bucket", "big.bin"); err != nil || saved.UploadId != "" {
+		t.Errorf("multipart state is not removed after completion: %+v, %v", saved, err)
+	}
+}
+
+func TestMultipartSourceChanged(t *testing.T) {
+	ts := newTestS3(t)
+
+	data := make([]byte, 2*minPartSize+1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	path := filepath.Join(t.TempDir(), "big.bin")
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		rewrite func(data []byte) time.Time // 修改源文件的内容，返回修改后的修改时间
+		changed bool
+	}{
+		{"same content", func(data []byte) time.Time { return modTime }, false},
+		{"same size and modification time", func(data []byte) time.Time {
+			data[0]++
+			return modTime
+		}, true},
+		{"same size and content of first part", func(data []byte) time.Time {
+			data[len(data)-1]++
+			return modTime.Add(time.Second)
+		}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := append([]byte{}, data...)
+			write := func(modTime time.Time) *File {
+				if err := os.WriteFile(path, content, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(path, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+				src, err := NewFile(path, NewLocal())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return src
+			}
+
+			key := strings.ReplaceAll(test.name, " ", "-")
+			previous := interruptedUpload(t, ts, write(modTime), key)
+			src := write(test.rewrite(content))
+
+			state, err := ts.client.multipartState(src, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resumed := state.UploadId == previous.UploadId && len(state.Parts) == 1; resumed == test.changed {
+				t.Errorf("unexpected multipart state after rewriting source: %+v", state)
+			}
+		})
+	}
+}
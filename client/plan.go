@@ -0,0 +1,81 @@
+package client
+
+import (
+	"fmt"
+	"io"
+)
+
+// Action 对单个文件的处理方式
+type Action string
+
+const (
+	ActionSkip     Action = "skip"     // md5一致，无需传输
+	ActionCopy     Action = "transfer" // 目标文件不存在，完整传输
+	ActionResume   Action = "resume"   // 目标文件小于源文件，续传
+	ActionTruncate Action = "truncate" // 目标文件不小于源文件且不一致，清空后重新传输
+	ActionDelete   Action = "delete"   // sync模式下删除目标上多余的文件
+)
+
+// Plan 单个文件的传输计划
+type Plan struct {
+	Action  Action // 处理方式
+	Source  string // 源文件路径
+	Target  string // 目标文件路径
+	Offset  int64  // 开始读取源文件的位置
+	Bytes   int64  // 需要传输的数据量
+	writer  *File  // 实际写入数据的文件，--atomic模式下为临时文件
+	chunked bool   // 从中断的分块传输续传，必须继续分块写入
+}
+
+/*
+newPlan 根据源文件和目标文件的大小以及md5决定传输方式
+@src: 要传输的源文件
+@dst: 对应的目标文件
+@valid: 源文件和目标文件的md5是否一致
+*/
+func newPlan(src, dst *File, valid bool) *Plan {
+	p := &Plan{Source: src.Path, Target: dst.Path, Bytes: src.Size, writer: dst}
+
+	if valid {
+		p.Action = ActionSkip
+		p.Bytes = 0
+	} else if dst.Size <= 0 {
+		p.Action = ActionCopy
+	} else if dst.Source() == Aws || dst.Source() == Scp || src.Size <= dst.Size {
+		// aws和scp只能完整上传，无法续传
+		p.Action = ActionTruncate
+	} else {
+		p.Action = ActionResume
+		p.Offset = dst.Size
+		p.Bytes = src.Size - dst.Size
+	}
+	return p
+}
+
+// String 格式化输出传输计划
+func (p *Plan) String() string {
+	if p.Action == ActionDelete {
+		return fmt.Sprintf("%-8s %14d %s", p.Action, p.Bytes, p.Target)
+	}
+	return fmt.Sprintf("%-8s %14d %s -> %s", p.Action, p.Bytes, p.Source, p.Target)
+}
+
+/*
+printPlans 输出所有传输计划及汇总
+@w: 输出对象
+@plans: 传输计划
+*/
+func printPlans(w io.Writer, plans []*Plan) {
+	count := make(map[Action]int)
+	total := int64(0)
+	for _, p := range plans {
+		_, _ = fmt.Fprintln(w, p.String())
+		count[p.Action]++
+		if p.Action != ActionDelete {
+			total += p.Bytes
+		}
+	}
+
+	_, _ = fmt.Fprintf(w, "\n%d to transfer, %d to resume, %d to truncate, %d to skip, %d to delete; %d bytes to transfer\n",
+		count[ActionCopy], count[ActionResume], count[ActionTruncate], count[ActionSkip], count[ActionDelete], total)
+}
@@ -3,7 +3,6 @@ package client
 import (
 	"fmt"
 	"net/url"
-	"os/user"
 	"strings"
 )
 
@@ -48,18 +47,7 @@ func CreateProxy(proxy string) (*Proxy, error) {
 		p.Path = "/"
 	}
 
-	if p.Scheme == "ssh" && p.Username == "" {
-		user, err := user.Current()
-		if err != nil {
-			return p, err
-		}
-		p.Username = user.Username
-
-		if p.Port == "0" {
-			p.Port = "22"
-		}
-	}
-
+	// ssh的用户名和端口在连接时根据~/.ssh/config确定
 	return p, nil
 }
 
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"sync"
+)
+
+// remoteHashCommands 各校验算法在服务器上可用的命令，按顺序检测，输出的第一列为校验值
+var remoteHashCommands = map[string][]string{
+	ChecksumMd5:    {"md5sum", "md5 -r", "openssl dgst -md5 -r"},
+	ChecksumSha256: {"sha256sum", "shasum -a 256", "openssl dgst -sha256 -r"},
+	ChecksumXxh3:   {"xxhsum -H3"},
+	ChecksumBlake3: {"b3sum"},
+}
+
+// sshHasher 通过ssh在服务器上执行命令计算校验值，避免下载文件；记录每个算法检测到的命令
+type sshHasher struct {
+	commands map[string]string // 算法对应的命令，空字符串表示服务器上没有可用的命令
+	lock     sync.Mutex
+}
+
+/*
+command 检测服务器上计算校验值的命令，每个算法只检测一次
+@client: ssh client
+@alg: 校验算法
+*/
+func (h *sshHasher) command(client *ssh.Client, alg string) string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if cmd, ok := h.commands[alg]; ok {
+		return cmd
+	}
+	if h.commands == nil {
+		h.commands = map[string]string{}
+	}
+
+	h.commands[alg] = ""
+	for _, cmd := range remoteHashCommands[alg] {
+		if _, err := sshRun(client, "command -v "+strings.Fields(cmd)[0]); err == nil {
+			log.Debugf("calculate %s on server by %s", alg, cmd)
+			h.commands[alg] = cmd
+			break
+		}
+	}
+	return h.commands[alg]
+}
+
+/*
+checksum 在服务器上计算文件的校验值，head-tail和服务器上没有对应命令的算法返回错误
+@client: ssh client
+@path: 文件路径
+@alg: 校验算法
+*/
+func (h *sshHasher) checksum(client *ssh.Client, path, alg string) (string, error) {
+	cmd := h.command(client, alg)
+	if cmd == "" {
+		return "", fmt.Errorf("no command to calculate %s on server", alg)
+	}
+
+	output, err := sshRun(client, fmt.Sprintf("%s %s", cmd, shellQuote(path)))
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) < 1 {
+		return "", fmt.Errorf("unexpected output of %s: %s", cmd, output)
+	}
+	// md5sum对含有特殊字符的文件名会在校验值前添加\，xxhsum的校验值以XXH3_开头
+	res := strings.TrimPrefix(strings.TrimPrefix(fields[0], "\\"), "XXH3_")
+	return strings.ToLower(res), nil
+}
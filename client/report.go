@@ -0,0 +1,145 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// 程序的退出码，无法启动传输时由log.Fatal以1退出
+const (
+	ExitSuccess  = 0 // 所有文件传输成功
+	ExitPartial  = 2 // 部分文件传输失败
+	ExitFailure  = 3 // 所有文件传输失败
+	ExitMismatch = 4 // 存在传输完成但校验失败的文件
+)
+
+// Report 单次传输的汇总结果
+type Report struct {
+	Source      string    `json:"source"`
+	Target      string    `json:"target"`
+	Job         string    `json:"job,omitempty"`
+	StartTime   time.Time `json:"start"`
+	EndTime     time.Time `json:"end"`
+	Duration    float64   `json:"duration"`   // 耗时，单位为秒
+	Throughput  float64   `json:"throughput"` // 平均速度，单位为bytes/s
+	Files       int       `json:"files"`
+	Transferred int       `json:"transferred"`
+	Resumed     int       `json:"resumed"`
+	Skipped     int       `json:"skipped"`
+	Failed      int       `json:"failed"`
+	Mismatched  int       `json:"mismatched"`
+	Deleted     int       `json:"deleted"`
+	Bytes       int64     `json:"bytes"` // 实际传输的数据量
+	FailedFiles []string  `json:"failed_files"`
+	Mismatches  []string  `json:"mismatches"`
+	lock        sync.Mutex
+}
+
+// newReport 新建传输汇总，记录开始时间
+func newReport(source, target *File) *Report {
+	report := &Report{StartTime: time.Now(), FailedFiles: []string{}, Mismatches: []string{}}
+	if source != nil {
+		report.Source = source.Path
+	}
+	if target != nil {
+		report.Target = target.Path
+	}
+	return report
+}
+
+/*
+add 记录单个文件的传输结果
+@action: 文件的处理方式
+@bytes: 实际传输的数据量
+*/
+func (report *Report) add(action Action, bytes int64) {
+	if report == nil {
+		return
+	}
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	switch action {
+	case ActionSkip:
+		report.Skipped++
+	case ActionResume:
+		report.Resumed++
+	case ActionDelete:
+		report.Deleted++
+		return
+	default:
+		report.Transferred++
+	}
+	report.Bytes += bytes
+}
+
+/*
+fail 记录传输失败的文件
+@path: 源文件路径
+@mismatch: 是否为校验失败
+*/
+func (report *Report) fail(path string, mismatch bool) {
+	if report == nil {
+		return
+	}
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	if mismatch {
+		report.Mismatched++
+		report.Mismatches = append(report.Mismatches, path)
+	} else {
+		report.Failed++
+		report.FailedFiles = append(report.FailedFiles, path)
+	}
+}
+
+// finish 记录结束时间并计算平均速度
+func (report *Report) finish() {
+	report.EndTime = time.Now()
+	report.Duration = report.EndTime.Sub(report.StartTime).Seconds()
+	if report.Duration > 0 {
+		report.Throughput = float64(report.Bytes) / report.Duration
+	}
+}
+
+// ExitCode 根据传输结果返回程序的退出码
+func (report *Report) ExitCode() int {
+	if report == nil {
+		return ExitSuccess
+	}
+	if report.Files > 0 && report.Failed+report.Mismatched >= report.Files {
+		return ExitFailure
+	} else if report.Mismatched > 0 {
+		return ExitMismatch
+	} else if report.Failed > 0 {
+		return ExitPartial
+	}
+	return ExitSuccess
+}
+
+/*
+Print 输出传输结果的汇总
+@w: 输出对象
+*/
+func (report *Report) Print(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "%d files: %d transferred, %d resumed, %d skipped, %d failed, %d mismatched, %d deleted; %d bytes in %.2fs (%.2f MB/s)\n",
+		report.Files, report.Transferred, report.Resumed, report.Skipped, report.Failed, report.Mismatched, report.Deleted,
+		report.Bytes, report.Duration, report.Throughput/1024/1024)
+}
+
+/*
+Write 将传输结果以json格式写入文件
+@path: 输出文件路径
+*/
+func (report *Report) Write(path string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// maxBackoff 两次重试之间的最长等待时间
+const maxBackoff = 5 * time.Minute
+
+/*
+backoff 返回第n次重试前的等待时间，每次重试后翻倍
+@attempt: 已经重试的次数
+*/
+func backoff(attempt int) time.Duration {
+	wait := time.Duration(opt.Backoff) * time.Second
+	for i := 0; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+/*
+retryable 检查错误是否可以通过重试解决，校验失败、权限不足和文件不存在时重试无意义
+@err: 错误
+*/
+func retryable(err error) bool {
+	return !errors.Is(err, ErrChecksumMismatch) && !errors.Is(err, os.ErrPermission) && !errors.Is(err, os.ErrNotExist)
+}
+
+/*
+reconnect 重新连接源和目标客户端；多个线程同时失败时，仅第一个线程重新连接；
+客户端在建立新的连接后才替换并关闭旧的连接，其他线程在旧连接上进行中的操作会失败并重试
+@generation: 失败的操作开始时的连接代数
+*/
+func (transfer *Transfer) reconnect(generation int) error {
+	transfer.lock.Lock()
+	defer transfer.lock.Unlock()
+
+	if generation != transfer.generation {
+		return nil
+	}
+	transfer.generation++
+
+	for _, file := range []*File{transfer.source, transfer.target} {
+		if file == nil {
+			continue
+		}
+		// 仅sftp、scp和ftp保持长连接，aws和http的每个请求各自建立连接，无需重新连接
+		client := file.client
+		if t := client.clientType(); t != Sftp && t != Scp && t != Ftp {
+			continue
+		}
+		log.Infof("reconnect to %s", client.clientType())
+		if err := client.connect(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+retry 执行操作，失败后等待一段时间，重新连接客户端再重试，最多重试--retries次
+@name: 操作的名称，用于日志
+@fn: 要执行的操作
+*/
+func (transfer *Transfer) retry(name string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		transfer.lock.Lock()
+		generation := transfer.generation
+		transfer.lock.Unlock()
+
+		err := fn()
+		if err == nil || !retryable(err) || attempt >= opt.Retries {
+			return err
+		}
+
+		wait := backoff(attempt)
+		log.Warnf("%s failed: %v, retry in %v (%d/%d)", name, err, wait, attempt+1, opt.Retries)
+		time.Sleep(wait)
+
+		if err := transfer.reconnect(generation); err != nil {
+			log.Warnf("failed to reconnect: %v", err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryReconnectWithStuckOperation(t *testing.T) {
+	saved := *opt
+	t.Cleanup(func() { *opt = saved })
+	opt.Retries, opt.Backoff = 3, 0
+
+	_, client := newTestSftp(t)
+	target, err := NewFile("/", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer := &Transfer{concurrent: 2, source: target, target: target}
+	old := client.sessions()
+
+	// 一个线程的操作在连接上卡住，不能阻塞其他线程重新连接
+	stuck, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	go func() {
+		_ = transfer.retry("stuck", func() error {
+			close(stuck)
+			<-release
+			return nil
+		})
+	}()
+	<-stuck
+
+	done := make(chan error)
+	go func() {
+		attempts := 0
+		done <- transfer.retry("broken", func() error {
+			attempts++
+			if attempts == 1 {
+				return errors.New("connection lost")
+			}
+			_, err := client.stat("/")
+			return err
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("reconnect is blocked by the stuck operation")
+	}
+	if transfer.generation != 1 || client.sessions() == old {
+		t.Errorf("client is not reconnected")
+	}
+}
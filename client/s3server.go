@@ -0,0 +1,1278 @@
+package client
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ygidtu/transfer/base/fi"
+)
+
+const (
+	s3Namespace    = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimeFormat   = "2006-01-02T15:04:05.000Z"
+	s3MaxKeys      = 1000
+	s3MaxParts     = 10000
+	s3UploadsDir   = ".transfer-multipart" // 根目录下保存未完成分片上传的目录
+	s3UploadConfig = "upload.json"
+)
+
+// s3Error 返回给客户端的s3错误
+type s3Error struct {
+	status  int
+	Code    string
+	Message string
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// s3Errorf 生成s3错误
+func s3Errorf(status int, code, format string, args ...any) *s3Error {
+	return &s3Error{status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+var (
+	s3NoSuchBucket      = s3Errorf(http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+	s3InvalidBucketName = s3Errorf(http.StatusBadRequest, "InvalidBucketName", "the specified bucket is not valid")
+	s3NoSuchKey         = s3Errorf(http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+	s3NoSuchUpload      = s3Errorf(http.StatusNotFound, "NoSuchUpload", "the specified multipart upload does not exist")
+	s3BadDigest         = s3Errorf(http.StatusBadRequest, "BadDigest", "the Content-MD5 or checksum you specified did not match what we received")
+)
+
+// s3ETag 缓存通过本服务器写入的文件的ETag，文件大小或修改时间变化后失效
+type s3ETag struct {
+	size    int64
+	modTime time.Time
+	value   string
+}
+
+// S3Server 内置的s3兼容服务器，根目录下的每个文件夹为一个bucket，文件为object
+type S3Server struct {
+	host      *Proxy   // 监听的地址，s3://access:secret@0.0.0.0:9000
+	root      string   // 服务器的根目录
+	accessKey string   // 静态的access key
+	secretKey string   // 静态的secret key
+	etags     sync.Map // 文件路径至s3ETag
+}
+
+/*
+NewS3Server 新建s3服务器
+@host: 监听的地址，用户名和密码分别为access key和secret key
+@root: 服务器的根目录
+*/
+func NewS3Server(host *Proxy, root string) (*S3Server, error) {
+	if host.Port == "" {
+		host.Port = "9000"
+	}
+	if host.Username == "" || host.Password == "" {
+		return nil, fmt.Errorf("please set the access key and secret key by s3://access:secret@ip:port")
+	}
+	return &S3Server{host: host, root: filepath.Clean(root), accessKey: host.Username, secretKey: host.Password}, nil
+}
+
+// startServer 启动s3服务器，设置了--tls-cert时使用https
+func (ss *S3Server) startServer() error {
+	if err := os.MkdirAll(filepath.Join(ss.root, s3UploadsDir), os.ModePerm); err != nil {
+		return err
+	}
+
+	log.Info("path: ", ss.root)
+	log.Info("host: ", ss.host.Addr())
+
+	server := &http.Server{Addr: ss.host.Addr(), Handler: ss}
+	if opt.TlsCert != "" {
+		config, err := serverTlsConfig()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = config
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// ServeHTTP 按照path-style的请求路径/bucket/key分发请求
+func (ss *S3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	w.Header().Set("X-Amz-Request-Id", strings.ToUpper(hex.EncodeToString(id)))
+	w.Header().Set("Server", "transfer")
+	log.Debugf("s3 %s: %s %s", r.RemoteAddr, r.Method, r.URL.RequestURI())
+
+	sig, err := ss.authenticate(r)
+	if err != nil {
+		ss.writeError(w, r, err)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	switch {
+	case bucket == "":
+		if r.Method != http.MethodGet {
+			err = s3Errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "the specified method is not allowed")
+		} else {
+			err = ss.listBuckets(w)
+		}
+	case !s3BucketName(bucket):
+		err = s3InvalidBucketName
+	case key == "":
+		err = ss.serveBucket(w, r, bucket, query)
+	default:
+		err = ss.serveObject(w, r, sig, bucket, key, query)
+	}
+
+	if err != nil {
+		ss.writeError(w, r, err)
+	}
+}
+
+// serveBucket 处理bucket相关的请求
+func (ss *S3Server) serveBucket(w http.ResponseWriter, r *http.Request, bucket string, query url.Values) error {
+	switch {
+	case r.Method == http.MethodGet && query.Has("location"):
+		if _, err := ss.bucketPath(bucket); err != nil {
+			return err
+		}
+		return ss.writeXML(w, http.StatusOK, &struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+		}{})
+	case r.Method == http.MethodGet:
+		for key := range query {
+			switch key {
+			case "list-type", "prefix", "delimiter", "max-keys", "continuation-token", "start-after", "marker", "encoding-type", "fetch-owner", "x-id":
+			default:
+				if strings.HasPrefix(key, "X-Amz-") {
+					continue
+				}
+				return s3Errorf(http.StatusNotImplemented, "NotImplemented", "%s is not implemented", key)
+			}
+		}
+		return ss.listObjects(w, bucket, query)
+	case r.Method == http.MethodHead:
+		_, err := ss.bucketPath(bucket)
+		return err
+	case r.Method == http.MethodPut:
+		if !s3BucketName(bucket) {
+			return s3InvalidBucketName
+		}
+		path, err := confinePath(ss.root, bucket)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err == nil {
+			return s3Errorf(http.StatusConflict, "BucketAlreadyOwnedByYou", "the bucket you tried to create already exists")
+		}
+		if err := os.Mkdir(path, os.ModePerm); err != nil {
+			return err
+		}
+		w.Header().Set("Location", "/"+bucket)
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case r.Method == http.MethodDelete:
+		path, err := ss.bucketPath(bucket)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return s3Errorf(http.StatusConflict, "BucketNotEmpty", "the bucket you tried to delete is not empty")
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case r.Method == http.MethodPost && query.Has("delete"):
+		return ss.deleteObjects(w, r, bucket)
+	}
+	return s3Errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "the specified method is not allowed")
+}
+
+// serveObject 处理object相关的请求
+func (ss *S3Server) serveObject(w http.ResponseWriter, r *http.Request, sig *s3Signature, bucket, key string, query url.Values) error {
+	uploadId := query.Get("uploadId")
+	copySource := r.Header.Get("X-Amz-Copy-Source")
+
+	switch r.Method {
+	case http.MethodGet:
+		if uploadId != "" {
+			return ss.listParts(w, bucket, key, uploadId, query)
+		}
+		return ss.getObject(w, r, bucket, key)
+	case http.MethodHead:
+		return ss.getObject(w, r, bucket, key)
+	case http.MethodPut:
+		if uploadId != "" {
+			return ss.uploadPart(w, r, sig, bucket, key, uploadId, query.Get("partNumber"))
+		} else if copySource != "" {
+			return ss.copyObject(w, r, bucket, key, copySource)
+		}
+		return ss.putObject(w, r, sig, bucket, key)
+	case http.MethodPost:
+		if query.Has("uploads") {
+			return ss.createUpload(w, r, bucket, key)
+		} else if uploadId != "" {
+			return ss.completeUpload(w, r, bucket, key, uploadId)
+		}
+	case http.MethodDelete:
+		if uploadId != "" {
+			return ss.abortUpload(w, bucket, key, uploadId)
+		}
+		return ss.deleteObject(w, bucket, key)
+	}
+	return s3Errorf(http.StatusMethodNotAllowed, "MethodNotAllowed", "the specified method is not allowed")
+}
+
+// writeXML 返回xml格式的响应
+func (ss *S3Server) writeXML(w http.ResponseWriter, status int, value any) error {
+	data, err := xml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(data)
+	return nil
+}
+
+// writeError 返回s3格式的错误，文件系统的错误转换为对应的s3错误
+func (ss *S3Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var s3Err *s3Error
+	switch {
+	case errors.As(err, &s3Err):
+	case errors.Is(err, os.ErrNotExist):
+		s3Err = s3NoSuchKey
+	case errors.Is(err, os.ErrPermission), strings.Contains(err.Error(), " is out of "):
+		s3Err = s3Errorf(http.StatusForbidden, "AccessDenied", "access denied")
+	default:
+		log.Warnf("s3 %s: %s %s: %v", r.RemoteAddr, r.Method, r.URL.Path, err)
+		s3Err = s3Errorf(http.StatusInternalServerError, "InternalError", "%v", err)
+	}
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(s3Err.status)
+		return
+	}
+	_ = ss.writeXML(w, s3Err.status, &struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string
+		Message  string
+		Resource string
+	}{Code: s3Err.Code, Message: s3Err.Message, Resource: r.URL.Path})
+}
+
+/*
+s3BucketName 检查bucket名称是否符合s3的命名规则，以.开头的本地目录（如分块上传的临时目录）不会作为bucket
+@name: bucket名称
+*/
+func s3BucketName(name string) bool {
+	if len(name) < 3 || len(name) > 63 || strings.Contains(name, "..") || net.ParseIP(name) != nil {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case (c == '.' || c == '-') && i > 0 && i < len(name)-1:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// bucketPath 返回bucket对应的本地目录，名称不合法时返回InvalidBucketName，不存在时返回NoSuchBucket
+func (ss *S3Server) bucketPath(bucket string) (string, error) {
+	if !s3BucketName(bucket) {
+		return "", s3InvalidBucketName
+	}
+	path, err := confinePath(ss.root, bucket)
+	if err != nil {
+		return path, err
+	}
+	if stat, err := os.Stat(path); err != nil || !stat.IsDir() {
+		return path, s3NoSuchBucket
+	}
+	return path, nil
+}
+
+// objectPath 返回object对应的本地路径，key以相对路径传入，避免与根目录的路径混淆
+func (ss *S3Server) objectPath(bucket, key string) (string, error) {
+	if _, err := ss.bucketPath(bucket); err != nil {
+		return "", err
+	}
+	// 包含.或..的key无法与本地路径一一对应
+	for _, name := range strings.Split(key, "/") {
+		if name == "." || name == ".." {
+			return "", s3Errorf(http.StatusBadRequest, "InvalidArgument", "the key could not contain . or .. as path segment")
+		}
+	}
+	return confinePath(ss.root, bucket+"/"+key)
+}
+
+// etag 返回文件的ETag，通过本服务器写入的文件为md5，其他文件由修改时间和大小生成
+func (ss *S3Server) etag(path string, stat os.FileInfo) string {
+	if value, ok := ss.etags.Load(path); ok {
+		if etag := value.(s3ETag); etag.size == stat.Size() && etag.modTime.Equal(stat.ModTime()) {
+			return etag.value
+		}
+	}
+	return fmt.Sprintf("\"%x-%x\"", stat.ModTime().UnixNano(), stat.Size())
+}
+
+// setETag 记录刚写入的文件的ETag
+func (ss *S3Server) setETag(path, etag string) {
+	if stat, err := os.Stat(path); err == nil {
+		ss.etags.Store(path, s3ETag{size: stat.Size(), modTime: stat.ModTime(), value: etag})
+	}
+}
+
+// listBuckets 列出根目录下的所有文件夹
+func (ss *S3Server) listBuckets(w http.ResponseWriter) error {
+	entries, err := os.ReadDir(ss.root)
+	if err != nil {
+		return err
+	}
+
+	type bucket struct {
+		Name         string
+		CreationDate string
+	}
+	var buckets []bucket
+	for _, entry := range entries {
+		if !entry.IsDir() || !s3BucketName(entry.Name()) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			buckets = append(buckets, bucket{Name: entry.Name(), CreationDate: info.ModTime().UTC().Format(s3TimeFormat)})
+		}
+	}
+
+	owner := ss.accessKey
+	return ss.writeXML(w, http.StatusOK, &struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Owner   struct{ ID, DisplayName string }
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}{Xmlns: s3Namespace, Owner: struct{ ID, DisplayName string }{owner, owner}, Buckets: buckets})
+}
+
+// s3Entry 列出的object或者公共前缀
+type s3Entry struct {
+	key    string
+	prefix bool
+	path   string
+	info   os.FileInfo
+}
+
+/*
+listEntries 列出bucket下以prefix开头的object，并按照delimiter合并公共前缀，结果按照key排序
+@root: bucket的本地目录
+@prefix: object的前缀
+@delimiter: 公共前缀的分隔符
+*/
+func (ss *S3Server) listEntries(root, prefix, delimiter string) ([]s3Entry, error) {
+	// 只遍历前缀所在的目录
+	start := root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		path, err := confinePath(root, prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		start = path
+	}
+
+	var entries []s3Entry
+	prefixes := map[string]bool{}
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		key := filepath.ToSlash(rel)
+		if d.IsDir() && path != start && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			// 以/为分隔符时，前缀下的目录即为公共前缀，无需继续遍历
+			if path != start && delimiter == "/" && strings.HasPrefix(key+"/", prefix) {
+				prefixes[key+"/"] = true
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.HasPrefix(key, prefix) || (&File{Path: path}).IsPartial() {
+			return nil
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				prefixes[key[:len(prefix)+i+len(delimiter)]] = true
+				return nil
+			}
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		entries = append(entries, s3Entry{key: key, path: path, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for key := range prefixes {
+		entries = append(entries, s3Entry{key: key, prefix: true})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+// listObjects 处理ListObjects和ListObjectsV2，通过continuation-token或者marker分页
+func (ss *S3Server) listObjects(w http.ResponseWriter, bucket string, query url.Values) error {
+	root, err := ss.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
+
+	v2 := query.Get("list-type") == "2"
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys := s3MaxKeys
+	if value := query.Get("max-keys"); value != "" {
+		if maxKeys, err = strconv.Atoi(value); err != nil || maxKeys < 0 {
+			return s3Errorf(http.StatusBadRequest, "InvalidArgument", "invalid max-keys: %s", value)
+		}
+		if maxKeys > s3MaxKeys {
+			maxKeys = s3MaxKeys
+		}
+	}
+
+	marker := query.Get("marker")
+	if v2 {
+		marker = query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			data, err := base64.StdEncoding.DecodeString(token)
+			if err != nil {
+				return s3Errorf(http.StatusBadRequest, "InvalidArgument", "the continuation token provided is incorrect")
+			}
+			marker = string(data)
+		}
+	}
+
+	entries, err := ss.listEntries(root, prefix, delimiter)
+	if err != nil {
+		return err
+	}
+	start := sort.Search(len(entries), func(i int) bool { return entries[i].key > marker })
+	entries = entries[start:]
+	truncated := len(entries) > maxKeys
+	if truncated {
+		entries = entries[:maxKeys]
+	}
+
+	encode := func(value string) string {
+		if query.Get("encoding-type") == "url" {
+			return url.QueryEscape(value)
+		}
+		return value
+	}
+
+	type object struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	var contents []object
+	var prefixes []commonPrefix
+	for _, entry := range entries {
+		if entry.prefix {
+			prefixes = append(prefixes, commonPrefix{Prefix: encode(entry.key)})
+			continue
+		}
+		contents = append(contents, object{
+			Key:          encode(entry.key),
+			LastModified: entry.info.ModTime().UTC().Format(s3TimeFormat),
+			ETag:         ss.etag(entry.path, entry.info),
+			Size:         entry.info.Size(),
+			StorageClass: "STANDARD",
+		})
+	}
+
+	var next string
+	if truncated {
+		next = entries[len(entries)-1].key
+	}
+
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Xmlns                 string   `xml:"xmlns,attr"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		MaxKeys               int
+		KeyCount              *int   `xml:",omitempty"`
+		Marker                string `xml:",omitempty"`
+		NextMarker            string `xml:",omitempty"`
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		StartAfter            string `xml:",omitempty"`
+		EncodingType          string `xml:",omitempty"`
+		IsTruncated           bool
+		Contents              []object
+		CommonPrefixes        []commonPrefix
+	}{
+		Xmlns: s3Namespace, Name: bucket, Prefix: encode(prefix), Delimiter: encode(delimiter), MaxKeys: maxKeys,
+		EncodingType: query.Get("encoding-type"), IsTruncated: truncated, Contents: contents, CommonPrefixes: prefixes,
+	}
+	if v2 {
+		count := len(entries)
+		result.KeyCount = &count
+		result.ContinuationToken = query.Get("continuation-token")
+		result.StartAfter = encode(query.Get("start-after"))
+		if next != "" {
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(next))
+		}
+	} else {
+		result.Marker = encode(marker)
+		result.NextMarker = encode(next)
+	}
+	return ss.writeXML(w, http.StatusOK, &result)
+}
+
+// getObject 处理GetObject和HeadObject，支持Range请求；以/结尾的key对应目录
+func (ss *S3Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	path, err := ss.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(path)
+	if err != nil || stat.IsDir() != strings.HasSuffix(key, "/") {
+		return s3NoSuchKey
+	}
+
+	header := w.Header()
+	header.Set("Last-Modified", stat.ModTime().UTC().Format(http.TimeFormat))
+	header.Set("X-Amz-Meta-"+fi.AwsMetaModTime, stat.ModTime().UTC().Format(time.RFC3339Nano))
+	header.Set("X-Amz-Meta-"+fi.AwsMetaMode, strconv.FormatUint(uint64(stat.Mode().Perm()), 8))
+
+	if stat.IsDir() {
+		header.Set("ETag", fmt.Sprintf("\"%x\"", md5.Sum(nil)))
+		header.Set("Content-Type", "application/x-directory")
+		header.Set("Content-Length", "0")
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	header.Set("ETag", ss.etag(path, stat))
+	http.ServeContent(w, r, "", stat.ModTime(), f)
+	return nil
+}
+
+/*
+setMetadata 根据x-amz-meta-mtime和x-amz-meta-mode修改文件的修改时间和权限
+@path: 文件路径
+@metadata: 请求头或者分片上传时保存的metadata
+*/
+func (ss *S3Server) setMetadata(path string, metadata map[string]string) error {
+	if value, ok := metadata[fi.AwsMetaMode]; ok {
+		if mode, err := strconv.ParseUint(value, 8, 32); err == nil {
+			if err := os.Chmod(path, os.FileMode(mode).Perm()); err != nil {
+				return err
+			}
+		}
+	}
+	if value, ok := metadata[fi.AwsMetaModTime]; ok {
+		if modTime, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return os.Chtimes(path, modTime, modTime)
+		}
+	}
+	return nil
+}
+
+// metadata 读取请求头中的x-amz-meta-*
+func (ss *S3Server) metadata(r *http.Request) map[string]string {
+	metadata := map[string]string{}
+	for key, values := range r.Header {
+		if name := strings.ToLower(key); strings.HasPrefix(name, "x-amz-meta-") && len(values) > 0 {
+			metadata[strings.TrimPrefix(name, "x-amz-meta-")] = values[0]
+		}
+	}
+	return metadata
+}
+
+/*
+s3Checksum 返回请求要求验证的checksum算法，x-amz-checksum-*可能在请求头或者aws-chunked的trailer中
+@r: 客户端的请求
+*/
+func s3Checksum(r *http.Request) (string, hash.Hash) {
+	name := strings.ToLower(r.Header.Get("X-Amz-Trailer"))
+	if name == "" {
+		for key := range r.Header {
+			if strings.HasPrefix(strings.ToLower(key), "x-amz-checksum-") && strings.ToLower(key) != "x-amz-checksum-mode" {
+				name = strings.ToLower(key)
+			}
+		}
+	}
+
+	switch name {
+	case "x-amz-checksum-crc32":
+		return name, crc32.NewIEEE()
+	case "x-amz-checksum-crc32c":
+		return name, crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "x-amz-checksum-sha1":
+		return name, sha1.New()
+	case "x-amz-checksum-sha256":
+		return name, sha256.New()
+	}
+	return "", nil
+}
+
+/*
+receive 将请求体写入临时文件，并验证Content-MD5和x-amz-checksum-*，返回临时文件路径和md5
+@w: 验证通过的checksum写入响应头
+@r: 客户端的请求
+@sig: 通过验证的请求签名
+@dir: 临时文件所在的目录
+*/
+func (ss *S3Server) receive(w http.ResponseWriter, r *http.Request, sig *s3Signature, dir string) (string, []byte, error) {
+	body, err := ss.payload(r, sig)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", nil, err
+	}
+
+	f, err := os.CreateTemp(dir, ".transfer-*.partial")
+	if err != nil {
+		return "", nil, err
+	}
+
+	md5Hash := md5.New()
+	writers := []io.Writer{f, md5Hash}
+	checksumName, checksum := s3Checksum(r)
+	if checksum != nil {
+		writers = append(writers, checksum)
+	}
+
+	_, err = io.Copy(io.MultiWriter(writers...), body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if expected := r.Header.Get("Content-Md5"); expected != "" && expected != base64.StdEncoding.EncodeToString(md5Hash.Sum(nil)) {
+			err = s3BadDigest
+		}
+	}
+	if err == nil && checksum != nil {
+		expected := r.Header.Get(checksumName)
+		if expected == "" {
+			expected = body.trailers[checksumName]
+		}
+		if value := base64.StdEncoding.EncodeToString(checksum.Sum(nil)); expected != "" && expected != value {
+			err = s3BadDigest
+		} else {
+			w.Header().Set(checksumName, value)
+		}
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), md5Hash.Sum(nil), nil
+}
+
+// putObject 处理PutObject，先写入临时文件再重命名，以/结尾的空object创建目录
+func (ss *S3Server) putObject(w http.ResponseWriter, r *http.Request, sig *s3Signature, bucket, key string) error {
+	path, err := ss.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(key, "/") {
+		if err := os.MkdirAll(path, os.ModePerm); err != nil {
+			return err
+		}
+		w.Header().Set("ETag", fmt.Sprintf("\"%x\"", md5.Sum(nil)))
+		return nil
+	}
+
+	temp, sum, err := ss.receive(w, r, sig, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	return ss.commit(w, temp, path, fmt.Sprintf("\"%x\"", sum), ss.metadata(r))
+}
+
+/*
+commit 将临时文件重命名为目标文件，并设置metadata和ETag
+@temp: 临时文件
+@path: 目标文件
+@etag: 目标文件的ETag
+@metadata: 目标文件的metadata
+*/
+func (ss *S3Server) commit(w http.ResponseWriter, temp, path, etag string, metadata map[string]string) error {
+	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		_ = os.Remove(temp)
+		return s3Errorf(http.StatusConflict, "InvalidRequest", "a directory with the same name already exists")
+	}
+	// 临时文件的权限为0600，与普通新建的文件保持一致
+	if err := os.Chmod(temp, 0644); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, path); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	if err := ss.setMetadata(path, metadata); err != nil {
+		return err
+	}
+
+	ss.setETag(path, etag)
+	w.Header().Set("ETag", etag)
+	return nil
+}
+
+// deleteObject 处理DeleteObject，不存在的object同样视为删除成功
+func (ss *S3Server) deleteObject(w http.ResponseWriter, bucket, key string) error {
+	path, err := ss.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := ss.remove(bucket, path, key); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// remove 删除文件，以/结尾的key仅删除空目录；与s3一致，删除后不再保留空的父目录
+func (ss *S3Server) remove(bucket, path, key string) error {
+	stat, err := os.Stat(path)
+	if err != nil || stat.IsDir() != strings.HasSuffix(key, "/") {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !stat.IsDir() {
+		return err
+	}
+	ss.etags.Delete(path)
+
+	root := filepath.Join(ss.root, bucket)
+	for dir := filepath.Dir(path); strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// deleteObjects 处理DeleteObjects，批量删除object
+func (ss *S3Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	var request struct {
+		Quiet  bool
+		Object []struct{ Key string }
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		return s3Errorf(http.StatusBadRequest, "MalformedXML", "the XML you provided was not well-formed")
+	}
+	if _, err := ss.bucketPath(bucket); err != nil {
+		return err
+	}
+
+	type deleted struct{ Key string }
+	type failed struct{ Key, Code, Message string }
+	var results []deleted
+	var errs []failed
+	for _, object := range request.Object {
+		path, err := ss.objectPath(bucket, object.Key)
+		if err == nil {
+			err = ss.remove(bucket, path, object.Key)
+		}
+		if err != nil {
+			errs = append(errs, failed{Key: object.Key, Code: "AccessDenied", Message: err.Error()})
+		} else if !request.Quiet {
+			results = append(results, deleted{Key: object.Key})
+		}
+	}
+
+	return ss.writeXML(w, http.StatusOK, &struct {
+		XMLName xml.Name  `xml:"DeleteResult"`
+		Xmlns   string    `xml:"xmlns,attr"`
+		Deleted []deleted `xml:"Deleted"`
+		Error   []failed  `xml:"Error"`
+	}{Xmlns: s3Namespace, Deleted: results, Error: errs})
+}
+
+/*
+copySource 解析x-amz-copy-source，返回源文件的本地路径和信息
+@source: bucket/key格式，经过url编码
+*/
+func (ss *S3Server) copySource(source string) (string, os.FileInfo, error) {
+	source, _, _ = strings.Cut(source, "?")
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		return "", nil, s3Errorf(http.StatusBadRequest, "InvalidArgument", "invalid x-amz-copy-source")
+	}
+
+	bucket, key, _ := strings.Cut(source, "/")
+	if strings.HasPrefix(bucket, ".") || key == "" {
+		return "", nil, s3Errorf(http.StatusBadRequest, "InvalidArgument", "invalid x-amz-copy-source")
+	}
+	path, err := ss.objectPath(bucket, key)
+	if err != nil {
+		return "", nil, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil || stat.IsDir() {
+		return "", nil, s3NoSuchKey
+	}
+	return path, stat, nil
+}
+
+/*
+copyRange 将源文件的特定范围复制至dir下的临时文件，返回临时文件路径和md5
+@path: 源文件
+@offset: 开始复制的位置
+@size: 复制的大小
+@dir: 临时文件所在的目录
+*/
+func (ss *S3Server) copyRange(path string, offset, size int64, dir string) (string, []byte, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", nil, err
+	}
+	f, err := os.CreateTemp(dir, ".transfer-*.partial")
+	if err != nil {
+		return "", nil, err
+	}
+
+	h := md5.New()
+	_, err = io.Copy(io.MultiWriter(f, h), io.NewSectionReader(src, offset, size))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), h.Sum(nil), nil
+}
+
+// copyObject 处理CopyObject，复制自身时仅替换metadata
+func (ss *S3Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key, source string) error {
+	src, stat, err := ss.copySource(source)
+	if err != nil {
+		return err
+	}
+	path, err := ss.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	metadata := map[string]string{
+		fi.AwsMetaModTime: stat.ModTime().UTC().Format(time.RFC3339Nano),
+		fi.AwsMetaMode:    strconv.FormatUint(uint64(stat.Mode().Perm()), 8),
+	}
+	if strings.EqualFold(r.Header.Get("X-Amz-Metadata-Directive"), "REPLACE") {
+		metadata = ss.metadata(r)
+	}
+
+	etag := ss.etag(src, stat)
+	if src == path {
+		if err := ss.setMetadata(path, metadata); err != nil {
+			return err
+		}
+		ss.setETag(path, etag)
+	} else {
+		temp, sum, err := ss.copyRange(src, 0, stat.Size(), filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		etag = fmt.Sprintf("\"%x\"", sum)
+		if err := ss.commit(w, temp, path, etag, metadata); err != nil {
+			return err
+		}
+	}
+
+	modTime := stat.ModTime()
+	if stat, err := os.Stat(path); err == nil {
+		modTime = stat.ModTime()
+	}
+	return ss.writeXML(w, http.StatusOK, &struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		LastModified string
+		ETag         string
+	}{LastModified: modTime.UTC().Format(s3TimeFormat), ETag: etag})
+}
+
+// s3Upload 未完成的分片上传
+type s3Upload struct {
+	Bucket   string            `json:"bucket"`
+	Key      string            `json:"key"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// uploadPath 返回分片上传的目录，uploadId只能包含16进制字符
+func (ss *S3Server) uploadPath(uploadId string) (string, error) {
+	if _, err := hex.DecodeString(uploadId); err != nil || uploadId == "" {
+		return "", s3NoSuchUpload
+	}
+	return filepath.Join(ss.root, s3UploadsDir, uploadId), nil
+}
+
+/*
+loadUpload 读取分片上传的信息，并确认其与请求的bucket和key一致
+@bucket: 请求的bucket
+@key: 请求的key
+@uploadId: 分片上传的id
+*/
+func (ss *S3Server) loadUpload(bucket, key, uploadId string) (string, *s3Upload, error) {
+	dir, err := ss.uploadPath(uploadId)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, s3UploadConfig))
+	if err != nil {
+		return "", nil, s3NoSuchUpload
+	}
+
+	upload := &s3Upload{}
+	if err := json.Unmarshal(data, upload); err != nil {
+		return "", nil, err
+	}
+	if upload.Bucket != bucket || upload.Key != key {
+		return "", nil, s3NoSuchUpload
+	}
+	return dir, upload, nil
+}
+
+// createUpload 处理CreateMultipartUpload
+func (ss *S3Server) createUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if _, err := ss.objectPath(bucket, key); err != nil {
+		return err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	uploadId := hex.EncodeToString(id)
+
+	dir, _ := ss.uploadPath(uploadId)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&s3Upload{Bucket: bucket, Key: key, Metadata: ss.metadata(r)})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, s3UploadConfig), data, 0644); err != nil {
+		return err
+	}
+
+	return ss.writeXML(w, http.StatusOK, &struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Xmlns: s3Namespace, Bucket: bucket, Key: key, UploadId: uploadId})
+}
+
+// s3Part 已上传的分片，保存为<编号>.<md5>
+type s3Part struct {
+	number int
+	etag   string
+	path   string
+	info   os.FileInfo
+}
+
+// parts 返回已上传的分片，按照编号排序
+func (ss *S3Server) parts(dir string) (map[int]s3Part, []int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parts := map[int]s3Part{}
+	var numbers []int
+	for _, entry := range entries {
+		name, sum, ok := strings.Cut(entry.Name(), ".")
+		number, err := strconv.Atoi(name)
+		if !ok || err != nil || len(sum) != 32 {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		parts[number] = s3Part{number: number, etag: fmt.Sprintf("\"%s\"", sum), path: filepath.Join(dir, entry.Name()), info: info}
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	return parts, numbers, nil
+}
+
+// uploadPart 处理UploadPart和UploadPartCopy，相同编号的分片会覆盖之前上传的分片
+func (ss *S3Server) uploadPart(w http.ResponseWriter, r *http.Request, sig *s3Signature, bucket, key, uploadId, partNumber string) error {
+	number, err := strconv.Atoi(partNumber)
+	if err != nil || number < 1 || number > s3MaxParts {
+		return s3Errorf(http.StatusBadRequest, "InvalidArgument", "part number must be an integer between 1 and %d", s3MaxParts)
+	}
+	dir, _, err := ss.loadUpload(bucket, key, uploadId)
+	if err != nil {
+		return err
+	}
+
+	var temp string
+	var sum []byte
+	source := r.Header.Get("X-Amz-Copy-Source")
+	if source != "" {
+		src, stat, err := ss.copySource(source)
+		if err != nil {
+			return err
+		}
+
+		offset, size := int64(0), stat.Size()
+		if value := r.Header.Get("X-Amz-Copy-Source-Range"); value != "" {
+			var end int64
+			if _, err := fmt.Sscanf(value, "bytes=%d-%d", &offset, &end); err != nil || offset < 0 || end < offset || end >= stat.Size() {
+				return s3Errorf(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "the requested range is not satisfiable")
+			}
+			size = end - offset + 1
+		}
+		if temp, sum, err = ss.copyRange(src, offset, size, dir); err != nil {
+			return err
+		}
+	} else if temp, sum, err = ss.receive(w, r, sig, dir); err != nil {
+		return err
+	}
+
+	// 删除相同编号的旧分片
+	if parts, _, err := ss.parts(dir); err == nil {
+		if part, ok := parts[number]; ok {
+			_ = os.Remove(part.path)
+		}
+	}
+	if err := os.Rename(temp, filepath.Join(dir, fmt.Sprintf("%05d.%x", number, sum))); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+
+	etag := fmt.Sprintf("\"%x\"", sum)
+	if source == "" {
+		w.Header().Set("ETag", etag)
+		return nil
+	}
+	return ss.writeXML(w, http.StatusOK, &struct {
+		XMLName      xml.Name `xml:"CopyPartResult"`
+		LastModified string
+		ETag         string
+	}{LastModified: time.Now().UTC().Format(s3TimeFormat), ETag: etag})
+}
+
+// listParts 处理ListParts，通过part-number-marker分页
+func (ss *S3Server) listParts(w http.ResponseWriter, bucket, key, uploadId string, query url.Values) error {
+	dir, _, err := ss.loadUpload(bucket, key, uploadId)
+	if err != nil {
+		return err
+	}
+	parts, numbers, err := ss.parts(dir)
+	if err != nil {
+		return err
+	}
+
+	marker, _ := strconv.Atoi(query.Get("part-number-marker"))
+	maxParts := s3MaxKeys
+	if value, err := strconv.Atoi(query.Get("max-parts")); err == nil && value >= 0 && value < maxParts {
+		maxParts = value
+	}
+
+	start := sort.SearchInts(numbers, marker+1)
+	numbers = numbers[start:]
+	truncated := len(numbers) > maxParts
+	if truncated {
+		numbers = numbers[:maxParts]
+	}
+
+	type part struct {
+		PartNumber   int
+		LastModified string
+		ETag         string
+		Size         int64
+	}
+	result := struct {
+		XMLName              xml.Name `xml:"ListPartsResult"`
+		Xmlns                string   `xml:"xmlns,attr"`
+		Bucket               string
+		Key                  string
+		UploadId             string
+		PartNumberMarker     int
+		NextPartNumberMarker int
+		MaxParts             int
+		IsTruncated          bool
+		Part                 []part
+	}{Xmlns: s3Namespace, Bucket: bucket, Key: key, UploadId: uploadId, PartNumberMarker: marker, MaxParts: maxParts, IsTruncated: truncated}
+
+	for _, number := range numbers {
+		p := parts[number]
+		result.Part = append(result.Part, part{
+			PartNumber: number, LastModified: p.info.ModTime().UTC().Format(s3TimeFormat), ETag: p.etag, Size: p.info.Size(),
+		})
+		result.NextPartNumberMarker = number
+	}
+	return ss.writeXML(w, http.StatusOK, &result)
+}
+
+// completeUpload 处理CompleteMultipartUpload，按照请求中的顺序合并分片
+func (ss *S3Server) completeUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadId string) error {
+	dir, upload, err := ss.loadUpload(bucket, key, uploadId)
+	if err != nil {
+		return err
+	}
+	path, err := ss.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	var request struct {
+		Part []struct {
+			PartNumber int
+			ETag       string
+		}
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Part) == 0 {
+		return s3Errorf(http.StatusBadRequest, "MalformedXML", "the XML you provided was not well-formed")
+	}
+
+	parts, _, err := ss.parts(dir)
+	if err != nil {
+		return err
+	}
+	var selected []s3Part
+	for i, requested := range request.Part {
+		if i > 0 && requested.PartNumber <= request.Part[i-1].PartNumber {
+			return s3Errorf(http.StatusBadRequest, "InvalidPartOrder", "the list of parts was not in ascending order")
+		}
+		part, ok := parts[requested.PartNumber]
+		if !ok || strings.Trim(requested.ETag, "\"") != strings.Trim(part.etag, "\"") {
+			return s3Errorf(http.StatusBadRequest, "InvalidPart", "part %d could not be found", requested.PartNumber)
+		}
+		selected = append(selected, part)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".transfer-*.partial")
+	if err != nil {
+		return err
+	}
+
+	// ETag为所有分片md5的md5，后缀为分片数量
+	sums := md5.New()
+	for _, part := range selected {
+		if err = concatFile(f, part.path); err != nil {
+			break
+		}
+		sum, _ := hex.DecodeString(strings.Trim(part.etag, "\""))
+		sums.Write(sum)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	etag := fmt.Sprintf("\"%x-%d\"", sums.Sum(nil), len(selected))
+	if err := ss.commit(w, f.Name(), path, etag, upload.Metadata); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Warnf("failed to remove multipart upload %s: %v", uploadId, err)
+	}
+
+	return ss.writeXML(w, http.StatusOK, &struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{Xmlns: s3Namespace, Location: "/" + bucket + "/" + key, Bucket: bucket, Key: key, ETag: etag})
+}
+
+// concatFile 将文件的内容追加至f
+func concatFile(f *os.File, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(f, src)
+	return err
+}
+
+// abortUpload 处理AbortMultipartUpload，删除已上传的分片
+func (ss *S3Server) abortUpload(w http.ResponseWriter, bucket, key, uploadId string) error {
+	dir, _, err := ss.loadUpload(bucket, key, uploadId)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+testSource 在临时目录中生成随机内容的源文件
+@t: 当前测试
+@name: 文件名
+@size: 文件大小
+*/
+func testSource(t *testing.T, name string, size int) ([]byte, *File) {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewFile(path, NewLocal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, file
+}
+
+func TestS3ServerRoundTrip(t *testing.T) {
+	ts := newTestS3(t)
+	var lock sync.Mutex
+	progress := int64(0)
+	counter := func(n int64) {
+		lock.Lock()
+		defer lock.Unlock()
+		progress += n
+	}
+
+	small, src := testSource(t, "small.txt", 1024)
+	if err := ts.client.write(src, "dir/small.txt", counter); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(ts.root, "bucket", "dir", "small.txt")); err != nil || !bytes.Equal(content, small) {
+		t.Fatalf("uploaded file is different from source: %v", err)
+	}
+
+	r, err := ts.client.reader("dir/small.txt", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil || !bytes.Equal(content, small[100:]) {
+		t.Fatalf("failed to read from offset: %v", err)
+	}
+
+	if err := src.GetMd5(); err != nil {
+		t.Fatal(err)
+	}
+	remote := &File{Path: "dir/small.txt"}
+	if err := ts.client.getMd5(remote); err != nil || remote.Md5 != src.Md5 {
+		t.Errorf("checksum %s is different from %s: %v", remote.Md5, src.Md5, err)
+	}
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := ts.client.setStat("dir/small.txt", modTime, 0600); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := ts.client.stat("dir/small.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stat.ModTime().Equal(modTime) || stat.Mode().Perm() != 0600 || stat.Size() != int64(len(small)) {
+		t.Errorf("unexpected stat: %v %v %d", stat.ModTime(), stat.Mode(), stat.Size())
+	}
+
+	big, src := testSource(t, "big.bin", int(2*minPartSize)+1)
+	progress = 0
+	ts.received()
+	if err := ts.client.write(src, "dir/big.bin", counter); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(ts.root, "bucket", "dir", "big.bin")); err != nil || !bytes.Equal(content, big) {
+		t.Fatalf("uploaded file is different from source: %v", err)
+	}
+	if progress != int64(len(big)) {
+		t.Errorf("expect progress %d, got %d", len(big), progress)
+	}
+	parts := 0
+	for _, request := range ts.received() {
+		if strings.HasPrefix(request, "PUT ") && strings.Contains(request, "partNumber=") {
+			parts++
+		}
+	}
+	if parts != 3 {
+		t.Errorf("expect uploading 3 parts, got %d", parts)
+	}
+
+	if err := ts.client.rename("dir/big.bin", "dir/renamed.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if ts.client.exists("dir/big.bin") || !ts.client.exists("dir/renamed.bin") {
+		t.Errorf("failed to rename dir/big.bin")
+	}
+	if err := ts.client.remove("dir/renamed.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(ts.root, "bucket", "dir", "renamed.bin")); !os.IsNotExist(err) {
+		t.Errorf("file is not removed: %v", err)
+	}
+}
+
+func TestS3ServerListPages(t *testing.T) {
+	ts := newTestS3(t)
+
+	// 超过单次ListObjectsV2返回的1000个对象
+	root := filepath.Join(ts.root, "bucket", "many")
+	for i := 0; i < 1005; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("%d", i%3))
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%04d.txt", i)), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(ts.root, "bucket", "other.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.received()
+	files, err := ts.client.listFiles(&File{Path: "many"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, file := range files.Files {
+		if !strings.HasPrefix(file.Path, "many/") || seen[file.Path] {
+			t.Errorf("unexpected file %s", file.Path)
+		}
+		seen[file.Path] = true
+	}
+	if len(seen) != 1005 || files.Total != 1005 {
+		t.Errorf("expect 1005 files, got %d, %d bytes", len(seen), files.Total)
+	}
+
+	pages := 0
+	for _, request := range ts.received() {
+		if strings.HasPrefix(request, "GET /bucket?") && strings.Contains(request, "list-type=2") {
+			pages++
+		}
+	}
+	if pages != 2 {
+		t.Errorf("expect listing in 2 pages, got %d", pages)
+	}
+}
+
+func TestS3ServerAuth(t *testing.T) {
+	ts := newTestS3(t)
+	if err := os.WriteFile(filepath.Join(ts.root, "bucket", "file.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/", "/bucket/file.txt"} {
+		resp, err := http.Get(ts.url + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("anonymous request of %s returns %d", path, resp.StatusCode)
+		}
+	}
+
+	host, _ := CreateProxy("s3://127.0.0.1:0")
+	if _, err := NewS3Server(host, ts.root); err == nil {
+		t.Errorf("s3 server should not start without credentials")
+	}
+
+	// 分块上传的临时目录等不符合命名规则的目录不能作为bucket访问
+	for _, bucket := range []string{s3UploadsDir, ".", "Bucket", "ab"} {
+		if _, err := (&S3Server{root: ts.root}).bucketPath(bucket); err != s3InvalidBucketName {
+			t.Errorf("bucket %s should be invalid: %v", bucket, err)
+		}
+	}
+}
+
+func TestS3ServerConfine(t *testing.T) {
+	ts := newTestS3(t)
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(ts.root, "bucket", "file")); err != nil {
+		t.Fatal(err)
+	}
+
+	if r, err := ts.client.reader("file", 0); err == nil {
+		content, _ := io.ReadAll(r)
+		_ = r.Close()
+		t.Errorf("read through symlink out of root: %s", content)
+	}
+	if _, err := ts.client.stat("file"); err == nil {
+		t.Errorf("stat through symlink out of root")
+	}
+}
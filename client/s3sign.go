@@ -0,0 +1,333 @@
+package client
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3DateFormat     = "20060102T150405Z"
+	s3MaxSkew        = 15 * time.Minute
+	s3MaxChunkSize   = 64 * 1024 * 1024 // aws-chunked单个分块的最大长度，避免恶意请求占用内存
+	s3UnsignedHash   = "UNSIGNED-PAYLOAD"
+	s3Streaming      = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	s3StreamingTrail = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	s3UnsignedTrail  = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+// s3EmptyHash 空字符串的sha256
+var s3EmptyHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+// s3Signature 通过验证的请求签名，用于继续验证aws-chunked的分块签名
+type s3Signature struct {
+	key       []byte // 签名密钥
+	date      string // 请求时间，20060102T150405Z
+	scope     string // 20060102/region/s3/aws4_request
+	signature string // 请求的签名，作为第一个分块的前一个签名
+}
+
+/*
+s3Escape 按照aws的规则对字符串进行uri编码，除字母、数字和-_.~以外的字符均需要编码
+@value: 需要编码的字符串
+@slash: 是否保留路径中的/
+*/
+func s3Escape(value string, slash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && slash {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return sb.String()
+}
+
+// hmacSha256 使用key计算data的hmac-sha256
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sha256Hex 返回data的sha256的16进制字符串
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+authenticate 验证aws signature version 4签名，支持Authorization请求头和预签名url
+@r: 客户端的请求
+*/
+func (ss *S3Server) authenticate(r *http.Request) (*s3Signature, error) {
+	query := r.URL.Query()
+	var credential, signedHeaders, signature, date, payload string
+	presigned := query.Get("X-Amz-Algorithm") != ""
+
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, s3Algorithm+" ") {
+			return nil, s3Errorf(http.StatusForbidden, "AccessDenied", "only %s is supported", s3Algorithm)
+		}
+		for _, field := range strings.Split(strings.TrimPrefix(auth, s3Algorithm+" "), ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+			switch key {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				signature = value
+			}
+		}
+
+		date = r.Header.Get("X-Amz-Date")
+		if date == "" {
+			if t, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+				date = t.UTC().Format(s3DateFormat)
+			}
+		}
+		payload = r.Header.Get("X-Amz-Content-Sha256")
+		if payload == "" {
+			payload = s3UnsignedHash
+		}
+	} else if presigned {
+		if query.Get("X-Amz-Algorithm") != s3Algorithm {
+			return nil, s3Errorf(http.StatusForbidden, "AccessDenied", "only %s is supported", s3Algorithm)
+		}
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		signature = query.Get("X-Amz-Signature")
+		date = query.Get("X-Amz-Date")
+		payload = s3UnsignedHash
+		if value := r.Header.Get("X-Amz-Content-Sha256"); value != "" {
+			payload = value
+		}
+	} else {
+		return nil, s3Errorf(http.StatusForbidden, "AccessDenied", "anonymous access is forbidden")
+	}
+
+	// Credential=access/20060102/region/s3/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[3] != "s3" || parts[4] != "aws4_request" || signedHeaders == "" || signature == "" {
+		return nil, s3Errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "the authorization is malformed")
+	}
+	if parts[0] != ss.accessKey {
+		return nil, s3Errorf(http.StatusForbidden, "InvalidAccessKeyId", "the access key %s does not exist", parts[0])
+	}
+
+	t, err := time.Parse(s3DateFormat, date)
+	if err != nil || parts[1] != date[:8] {
+		return nil, s3Errorf(http.StatusForbidden, "AccessDenied", "invalid request date")
+	}
+	if presigned {
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || time.Now().After(t.Add(time.Duration(expires)*time.Second)) {
+			return nil, s3Errorf(http.StatusForbidden, "AccessDenied", "request has expired")
+		}
+	} else if skew := time.Since(t); skew > s3MaxSkew || skew < -s3MaxSkew {
+		return nil, s3Errorf(http.StatusForbidden, "RequestTimeTooSkewed", "the difference between the request time and the server's time is too large")
+	}
+
+	canonical := strings.Join([]string{
+		r.Method,
+		s3Escape(r.URL.Path, true),
+		s3CanonicalQuery(query),
+		s3CanonicalHeaders(r, signedHeaders),
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	sig := &s3Signature{date: date, scope: strings.Join(parts[1:], "/")}
+	sig.key = []byte("AWS4" + ss.secretKey)
+	for _, part := range parts[1:] {
+		sig.key = hmacSha256(sig.key, part)
+	}
+	sig.signature = hex.EncodeToString(hmacSha256(sig.key, strings.Join([]string{s3Algorithm, date, sig.scope, sha256Hex([]byte(canonical))}, "\n")))
+
+	if !hmac.Equal([]byte(sig.signature), []byte(signature)) {
+		log.Debugf("s3 canonical request: %q", canonical)
+		return nil, s3Errorf(http.StatusForbidden, "SignatureDoesNotMatch", "the request signature we calculated does not match the signature you provided")
+	}
+	return sig, nil
+}
+
+// s3CanonicalQuery 按照参数名和参数值排序并编码查询参数，预签名url的签名本身不参与计算
+func s3CanonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		if key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// s3CanonicalHeaders 生成参与签名的请求头，每行以\n结尾
+func s3CanonicalHeaders(r *http.Request, signedHeaders string) string {
+	var sb strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		values := r.Header.Values(name)
+		switch {
+		case name == "host":
+			values = []string{r.Host}
+		case name == "content-length" && len(values) == 0:
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		case name == "transfer-encoding" && len(values) == 0:
+			values = r.TransferEncoding
+		}
+
+		trimmed := make([]string, 0, len(values))
+		for _, value := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(value), " "))
+		}
+		sb.WriteString(name + ":" + strings.Join(trimmed, ",") + "\n")
+	}
+	return sb.String()
+}
+
+/*
+payload 根据x-amz-content-sha256返回请求体的reader，读取时验证内容的sha256或者aws-chunked分块的签名
+@r: 客户端的请求
+@sig: 通过验证的请求签名
+*/
+func (ss *S3Server) payload(r *http.Request, sig *s3Signature) (*s3Payload, error) {
+	body := &s3Payload{Reader: r.Body, trailers: map[string]string{}}
+
+	switch value := r.Header.Get("X-Amz-Content-Sha256"); value {
+	case "", s3UnsignedHash:
+	case s3Streaming, s3StreamingTrail, s3UnsignedTrail:
+		chunked := &s3ChunkReader{reader: bufio.NewReader(r.Body), trailer: value != s3Streaming, payload: body}
+		if value != s3UnsignedTrail {
+			chunked.sig, chunked.prev = sig, sig.signature
+		}
+		body.Reader = chunked
+	default:
+		if len(value) != 64 {
+			return nil, s3Errorf(http.StatusBadRequest, "InvalidArgument", "invalid x-amz-content-sha256: %s", value)
+		}
+		body.Reader = &s3HashReader{reader: r.Body, hash: sha256.New(), expected: strings.ToLower(value)}
+	}
+	return body, nil
+}
+
+// s3Payload 请求体，aws-chunked的trailer在读取完成后才可用
+type s3Payload struct {
+	io.Reader
+	trailers map[string]string
+}
+
+// s3HashReader 读取完成后验证内容的sha256
+type s3HashReader struct {
+	reader   io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+// Read 读取数据，到达末尾时验证sha256
+func (hr *s3HashReader) Read(p []byte) (int, error) {
+	n, err := hr.reader.Read(p)
+	hr.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(hr.hash.Sum(nil)) != hr.expected {
+		return n, s3Errorf(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "the provided x-amz-content-sha256 does not match what was computed")
+	}
+	return n, err
+}
+
+// s3ChunkReader 解码aws-chunked格式的请求体，并逐个验证分块的签名
+type s3ChunkReader struct {
+	reader  *bufio.Reader
+	sig     *s3Signature // 为空时不验证分块签名
+	prev    string       // 前一个分块的签名
+	trailer bool         // 最后的分块之后是否有trailer
+	payload *s3Payload   // 保存读取的trailer
+	chunk   []byte       // 当前分块中未读取的数据
+	done    bool
+}
+
+// Read 读取解码后的数据
+func (cr *s3ChunkReader) Read(p []byte) (int, error) {
+	for len(cr.chunk) == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if err := cr.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, cr.chunk)
+	cr.chunk = cr.chunk[n:]
+	return n, nil
+}
+
+// next 读取下一个分块，格式为size;chunk-signature=signature\r\ndata\r\n
+func (cr *s3ChunkReader) next() error {
+	line, err := cr.reader.ReadString('\n')
+	if err != nil {
+		return s3Errorf(http.StatusBadRequest, "IncompleteBody", "failed to read chunk: %v", err)
+	}
+
+	header, signature, _ := strings.Cut(strings.TrimSpace(line), ";")
+	size, err := strconv.ParseInt(header, 16, 64)
+	if err != nil || size < 0 || size > s3MaxChunkSize {
+		return s3Errorf(http.StatusBadRequest, "InvalidChunkSizeError", "invalid chunk size: %s", header)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(cr.reader, data); err != nil {
+		return s3Errorf(http.StatusBadRequest, "IncompleteBody", "failed to read chunk: %v", err)
+	}
+
+	if cr.sig != nil {
+		signature = strings.TrimPrefix(signature, "chunk-signature=")
+		expected := hex.EncodeToString(hmacSha256(cr.sig.key, strings.Join([]string{
+			s3Algorithm + "-PAYLOAD", cr.sig.date, cr.sig.scope, cr.prev, s3EmptyHash, sha256Hex(data),
+		}, "\n")))
+		if !hmac.Equal([]byte(expected), []byte(signature)) {
+			return s3Errorf(http.StatusForbidden, "SignatureDoesNotMatch", "the chunk signature does not match")
+		}
+		cr.prev = expected
+	}
+
+	if size > 0 {
+		// 分块数据之后的\r\n
+		_, _ = cr.reader.Discard(2)
+		cr.chunk = data
+		return nil
+	}
+
+	cr.done = true
+	if !cr.trailer {
+		return nil
+	}
+
+	// trailer以空行结束，trailer的签名不做验证
+	for {
+		line, err := cr.reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			cr.payload.trailers[strings.ToLower(key)] = strings.TrimSpace(value)
+		}
+		if line == "" && err == nil && len(cr.payload.trailers) > 0 || err != nil {
+			return nil
+		}
+	}
+}
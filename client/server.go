@@ -0,0 +1,63 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// server 服务端模式，通过特定协议将本地目录提供给客户端
+type server interface {
+	startServer() error
+}
+
+/*
+confinePath 将客户端请求中的路径视为相对于根目录的路径转换为服务器上的本地路径，
+并解析路径中包括最后一级在内的符号链接，确保其不会超出根目录
+@root: 服务器的根目录
+@path: 请求中的路径
+*/
+func confinePath(root, path string) (string, error) {
+	root = filepath.Clean(root)
+	res := filepath.Join(root, filepath.Clean("/"+path))
+	if res == root {
+		return res, nil
+	}
+
+	realRoot, err := resolvePath(root)
+	if err != nil {
+		return res, err
+	}
+	real, err := resolvePath(res)
+	if err != nil {
+		return res, err
+	}
+	if real != realRoot && !strings.HasPrefix(real, strings.TrimRight(realRoot, "/")+"/") {
+		return res, fmt.Errorf("%s is out of %s", path, root)
+	}
+	return res, nil
+}
+
+/*
+resolvePath 解析路径中的符号链接，路径不存在时解析其最近的已存在的上级目录
+@path: 本地路径
+*/
+func resolvePath(path string) (string, error) {
+	rest := ""
+	for {
+		res, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(res, rest), nil
+		} else if !os.IsNotExist(err) || filepath.Dir(path) == path {
+			return path, err
+		}
+
+		// 路径存在却无法解析，说明是指向不存在的文件的符号链接，写入时会在链接指向的位置新建文件
+		if _, err := os.Lstat(path); err == nil {
+			return path, fmt.Errorf("%s is a broken symbolic link", path)
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = filepath.Dir(path)
+	}
+}
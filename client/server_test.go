@@ -0,0 +1,59 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfinePath(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "dir"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(outside, "dir"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"link":     outside,
+		"file":     filepath.Join(outside, "secret.txt"),
+		"dangling": filepath.Join(outside, "new.txt"),
+		"inside":   filepath.Join(root, "dir"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path   string
+		expect string // 为空时应当拒绝
+	}{
+		{"/", root},
+		{"/dir/new.txt", filepath.Join(root, "dir", "new.txt")},
+		{"/../escape.txt", filepath.Join(root, "escape.txt")},
+		{"../../dir/new.txt", filepath.Join(root, "dir", "new.txt")},
+		{"/inside/new.txt", filepath.Join(root, "inside", "new.txt")},
+		{"/link", ""},
+		{"/link/secret.txt", ""},
+		{"/link/dir/new.txt", ""},
+		{"/file", ""},
+		{"/dangling", ""},
+		{"/dangling/new.txt", ""},
+	}
+
+	// 根目录末尾的/不影响结果
+	for _, r := range []string{root, root + "/"} {
+		for _, test := range tests {
+			res, err := confinePath(r, test.path)
+			if test.expect == "" && err == nil {
+				t.Errorf("%s should be refused, got %s", test.path, res)
+			} else if test.expect != "" && (err != nil || res != test.expect) {
+				t.Errorf("%s is resolved to %s, expect %s: %v", test.path, res, test.expect, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"sync"
+)
+
+// sftpSession 连接池中的sftp会话，busy记录正在使用该会话的文件数量
+type sftpSession struct {
+	client *sftp.Client
+	busy   int
+}
+
+// sftpPool sftp会话池，会话平均分布在多个ssh连接上，每个文件传输时占用最空闲的会话
+type sftpPool struct {
+	conns    []*ssh.Client   // ssh连接
+	jumps    [][]*ssh.Client // 每个ssh连接对应的跳板机
+	sessions []*sftpSession  // sftp会话
+	lock     sync.Mutex
+}
+
+/*
+newSftpPool 建立ssh连接和sftp会话
+@host: ssh host
+@proxy: ssh所需的proxy
+@rsa: 认证key地址
+@conns: ssh连接数量
+@sessions: sftp会话数量，至少为ssh连接数量
+*/
+func newSftpPool(host, proxy *Proxy, rsa string, conns, sessions int) (*sftpPool, error) {
+	if conns < 1 {
+		conns = 1
+	}
+	if sessions < conns {
+		sessions = conns
+	}
+
+	pool := &sftpPool{}
+	for i := 0; i < conns; i++ {
+		client, jumps, err := sshDial(host, proxy, rsa)
+		if err != nil {
+			_ = pool.close()
+			return nil, err
+		}
+		pool.conns = append(pool.conns, client)
+		pool.jumps = append(pool.jumps, jumps)
+	}
+
+	// 不使用并发写入，并发写入出错时目标文件可能长于实际写入的数据，续传时会在空洞之后追加；
+	// 大文件由分块传输并行写入，并从日志记录的连续位置续传
+	for i := 0; i < sessions; i++ {
+		client, err := sftp.NewClient(pool.conns[i%conns])
+		if err != nil {
+			_ = pool.close()
+			return nil, fmt.Errorf("failed to create client: %s", err)
+		}
+		pool.sessions = append(pool.sessions, &sftpSession{client: client})
+	}
+	log.Debugf("open %d sftp sessions over %d ssh connections", sessions, conns)
+	return pool, nil
+}
+
+// acquire 占用当前最空闲的sftp会话，会话本身支持并发使用，因此不会阻塞
+func (pool *sftpPool) acquire() *sftpSession {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	session := pool.sessions[0]
+	for _, s := range pool.sessions[1:] {
+		if s.busy < session.busy {
+			session = s
+		}
+	}
+	session.busy++
+	return session
+}
+
+// release 归还acquire占用的sftp会话
+func (pool *sftpPool) release(session *sftpSession) {
+	pool.lock.Lock()
+	session.busy--
+	pool.lock.Unlock()
+}
+
+// close 关闭所有sftp会话、ssh连接和跳板机
+func (pool *sftpPool) close() error {
+	var err error
+	for _, session := range pool.sessions {
+		if e := session.client.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for i, conn := range pool.conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+		for j := len(pool.jumps[i]) - 1; j >= 0; j-- {
+			_ = pool.jumps[i][j].Close()
+		}
+	}
+	return err
+}
+
+// pooledFile 占用sftp会话的文件，关闭文件时归还会话
+type pooledFile struct {
+	*sftp.File
+	pool    *sftpPool
+	session *sftpSession
+	once    sync.Once
+}
+
+func (f *pooledFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(func() { f.pool.release(f.session) })
+	return err
+}
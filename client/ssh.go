@@ -0,0 +1,216 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	px "golang.org/x/net/proxy"
+	"net"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// sshTimeout 建立ssh连接的超时时间
+const sshTimeout = 60 * time.Second
+
+// sshHop ssh连接链上的一个主机，已根据~/.ssh/config解析
+type sshHop struct {
+	Host       string
+	Port       string
+	User       string
+	Password   string
+	Identities []string // 配置文件中的IdentityFile
+}
+
+// Addr 返回host:port格式的地址
+func (hop *sshHop) Addr() string {
+	return net.JoinHostPort(hop.Host, hop.Port)
+}
+
+/*
+parseJumpHost 解析ProxyJump中的主机，格式为[user@]host[:port]或ssh://[user@]host[:port]
+@jump: 跳板机
+*/
+func parseJumpHost(jump string) (*Proxy, error) {
+	jump = strings.TrimSpace(jump)
+	if !strings.Contains(jump, "://") {
+		jump = "ssh://" + jump
+	}
+	return CreateProxy(jump)
+}
+
+/*
+resolveSSHHops 根据~/.ssh/config解析主机的HostName、Port、User、IdentityFile和ProxyJump，
+返回依次需要连接的跳板机以及主机本身；命令行中指定的用户名和端口优先于配置文件
+@host: 主机，host可以是配置文件中的别名
+@depth: ProxyJump的嵌套层数
+*/
+func resolveSSHHops(host *Proxy, depth int) ([]*sshHop, error) {
+	if depth > 10 {
+		return nil, fmt.Errorf("too many nested ProxyJump for %s", host.Host)
+	}
+
+	config, err := lookupSSHConfig(host.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	hop := &sshHop{Host: host.Host, Port: host.Port, User: host.Username, Password: host.Password, Identities: config.IdentityFiles}
+	if config.HostName != "" {
+		hop.Host = config.HostName
+	}
+	if hop.Port == "" {
+		hop.Port = config.Port
+	}
+	if hop.Port == "" {
+		hop.Port = "22"
+	}
+	if hop.User == "" {
+		hop.User = config.User
+	}
+	if hop.User == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		hop.User = u.Username
+	}
+
+	var hops []*sshHop
+	if config.ProxyJump != "" && strings.ToLower(config.ProxyJump) != "none" {
+		for _, jump := range strings.Split(config.ProxyJump, ",") {
+			p, err := parseJumpHost(jump)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ProxyJump %s of %s: %v", jump, host.Host, err)
+			}
+			res, err := resolveSSHHops(p, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			hops = append(hops, res...)
+		}
+	}
+	return append(hops, hop), nil
+}
+
+/*
+sshConfig 完成ssh认证和登录
+@hop: 要登录的主机
+@rsa: --rsa指定的私钥
+*/
+func sshConfig(hop *sshHop, rsa string) (*ssh.ClientConfig, error) {
+	callback, algorithms, err := hostKeyCallback(hop.Addr())
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:              hop.User,
+		Auth:              sshAuthMethods(hop.Password, append(hop.Identities, rsa)),
+		Timeout:           sshTimeout,
+		HostKeyCallback:   callback,
+		HostKeyAlgorithms: algorithms,
+	}, nil
+}
+
+// sshClientConn generate a ssh client connection
+func sshClientConn(conn net.Conn, hop *sshHop, rsa string) (*ssh.Client, error) {
+	config, err := sshConfig(hop, rsa)
+	if err != nil {
+		return nil, err
+	}
+
+	c, channels, reqs, err := ssh.NewClientConn(conn, hop.Addr(), config)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewClient(c, channels, reqs), nil
+}
+
+/*
+sshDial 依次经过所有跳板机连接到主机，返回主机的ssh客户端，以及需要一并关闭的跳板机客户端
+@host: 目标主机
+@proxy: socks5或ssh代理，ssh代理作为第一个跳板机
+@rsa: --rsa指定的私钥
+*/
+func sshDial(host, proxy *Proxy, rsa string) (*ssh.Client, []*ssh.Client, error) {
+	var hops []*sshHop
+	if proxy != nil && proxy.Scheme == "ssh" {
+		res, err := resolveSSHHops(proxy, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		hops = append(hops, res...)
+	}
+
+	res, err := resolveSSHHops(host, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	hops = append(hops, res...)
+
+	var clients []*ssh.Client
+	closeAll := func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			_ = clients[i].Close()
+		}
+	}
+
+	for i, hop := range hops {
+		var conn net.Conn
+		if len(clients) > 0 {
+			log.Infof("jump to %s through %s", hop.Addr(), hops[i-1].Addr())
+			conn, err = clients[len(clients)-1].Dial("tcp", hop.Addr())
+		} else if proxy != nil && proxy.Scheme == "socks5" {
+			log.Infof("dail through socks5 proxy: %s", proxy.Addr())
+			var dialer px.Dialer
+			dialer, err = px.SOCKS5("tcp", proxy.Addr(), nil, px.Direct)
+			if err == nil {
+				conn, err = dialer.Dial("tcp", hop.Addr())
+			}
+		} else {
+			conn, err = net.DialTimeout("tcp", hop.Addr(), sshTimeout)
+		}
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("failed to dial %s: %v", hop.Addr(), err)
+		}
+
+		client, err := sshClientConn(conn, hop, rsa)
+		if err != nil {
+			_ = conn.Close()
+			closeAll()
+			return nil, nil, fmt.Errorf("failed to login %s@%s: %v", hop.User, hop.Addr(), err)
+		}
+		clients = append(clients, client)
+	}
+	return clients[len(clients)-1], clients[:len(clients)-1], nil
+}
+
+/*
+sshRun 在服务器上执行命令并返回标准输出，失败时错误中包含标准错误输出
+@client: ssh client
+@cmd: 命令
+*/
+func sshRun(client *ssh.Client, cmd string) ([]byte, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	output, err := session.Output(cmd)
+	if err != nil {
+		return output, fmt.Errorf("%s: %v %s", cmd, err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// shellQuote 将路径转义为单引号包裹的shell参数
+func shellQuote(path string) string {
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
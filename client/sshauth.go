@@ -0,0 +1,288 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	agentOnce   sync.Once
+	agentClient agent.ExtendedAgent // SSH_AUTH_SOCK对应的ssh-agent
+
+	passphraseLock sync.Mutex
+	passphrases    = map[string][]byte{} // 已输入的私钥密码，避免重连时重复输入
+)
+
+// sshAgent 连接SSH_AUTH_SOCK对应的ssh-agent，未设置或无法连接时返回nil
+func sshAgent() agent.ExtendedAgent {
+	agentOnce.Do(func() {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return
+		}
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			log.Debugf("failed to connect ssh-agent %s: %v", sock, err)
+			return
+		}
+		agentClient = agent.NewClient(conn)
+	})
+	return agentClient
+}
+
+// isTerminal 检查是否可以从终端读取用户输入
+func isTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+/*
+prompt 在终端中提示用户输入
+@question: 提示信息
+@echo: 是否显示输入的内容
+*/
+func prompt(question string, echo bool) (string, error) {
+	if !isTerminal() {
+		return "", fmt.Errorf("unable to prompt for %q without terminal", strings.TrimSpace(question))
+	}
+
+	_, _ = fmt.Fprint(os.Stderr, question)
+	if echo {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	data, err := term.ReadPassword(int(os.Stdin.Fd()))
+	_, _ = fmt.Fprintln(os.Stderr)
+	return string(data), err
+}
+
+/*
+keyPassphrase 返回私钥的密码，优先读取--key-passphrase-file，否则在终端中提示输入
+@path: 私钥路径
+*/
+func keyPassphrase(path string) ([]byte, error) {
+	passphraseLock.Lock()
+	defer passphraseLock.Unlock()
+
+	if passphrase, ok := passphrases[path]; ok {
+		return passphrase, nil
+	}
+
+	var passphrase []byte
+	if opt.KeyPass != "" {
+		data, err := os.ReadFile(opt.KeyPass)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase file: %v", err)
+		}
+		passphrase = []byte(strings.TrimRight(string(data), "\r\n"))
+	} else {
+		res, err := prompt(fmt.Sprintf("Enter passphrase for key '%s': ", path), false)
+		if err != nil {
+			return nil, err
+		}
+		passphrase = []byte(res)
+	}
+
+	passphrases[path] = passphrase
+	return passphrase, nil
+}
+
+// lazySigner 加密的私钥在服务器接受对应的公钥之后才解密，避免无用的密码输入
+type lazySigner struct {
+	pub    ssh.PublicKey
+	load   func() (ssh.Signer, error)
+	once   sync.Once
+	signer ssh.Signer
+	err    error
+}
+
+func (s *lazySigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *lazySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+func (s *lazySigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.once.Do(func() {
+		s.signer, s.err = s.load()
+	})
+	if s.err != nil {
+		return nil, s.err
+	}
+	if as, ok := s.signer.(ssh.AlgorithmSigner); ok && algorithm != "" {
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+	return s.signer.Sign(rand, data)
+}
+
+/*
+keySigners 读取私钥以及同名的OpenSSH证书(<key>-cert.pub)，证书优先
+@path: 私钥路径
+*/
+func keySigners(path string) ([]ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pub ssh.PublicKey
+	load := func() (ssh.Signer, error) { return ssh.ParsePrivateKey(data) }
+	signer, err := ssh.ParsePrivateKey(data)
+
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		load = func() (ssh.Signer, error) {
+			passphrase, err := keyPassphrase(path)
+			if err != nil {
+				return nil, err
+			}
+			signer, err := ssh.ParsePrivateKeyWithPassphrase(data, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("unable to decrypt private key %s: %v", path, err)
+			}
+			return signer, nil
+		}
+
+		pub = missing.PublicKey
+		if pub == nil {
+			if data, err := os.ReadFile(path + ".pub"); err == nil {
+				pub, _, _, _, _ = ssh.ParseAuthorizedKey(data)
+			}
+		}
+		// 无法获取公钥时，只能直接解密
+		if pub == nil {
+			if signer, err = load(); err != nil {
+				return nil, err
+			}
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to parse private key %s: %v", path, err)
+	}
+
+	if signer != nil {
+		pub = signer.PublicKey()
+		load = func() (ssh.Signer, error) { return signer, nil }
+	}
+
+	var signers []ssh.Signer
+	if data, err := os.ReadFile(path + "-cert.pub"); err == nil {
+		key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+		if cert, ok := key.(*ssh.Certificate); err == nil && ok {
+			log.Debugf("found certificate of %s", path)
+			signers = append(signers, &lazySigner{pub: cert, load: func() (ssh.Signer, error) {
+				signer, err := load()
+				if err != nil {
+					return nil, err
+				}
+				return ssh.NewCertSigner(cert, signer)
+			}})
+		} else {
+			log.Warnf("unable to parse certificate %s-cert.pub: %v", path, err)
+		}
+	}
+
+	if signer != nil {
+		signers = append(signers, signer)
+	} else {
+		signers = append(signers, &lazySigner{pub: pub, load: load})
+	}
+	return signers, nil
+}
+
+/*
+identityFiles 返回需要尝试的私钥，~/.ssh/config和--rsa指定的私钥优先，其次为默认的id_ed25519、id_ecdsa和id_rsa
+@identities: 指定的私钥路径
+*/
+func identityFiles(identities []string) []string {
+	home := filepath.Join(os.Getenv("HOME"), ".ssh")
+	candidates := append([]string{}, identities...)
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		candidates = append(candidates, filepath.Join(home, name))
+	}
+
+	var files []string
+	seen := map[string]bool{}
+	for _, path := range candidates {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+/*
+sshAuthMethods 生成ssh的认证方式，依次尝试密码、ssh-agent和私钥、keyboard-interactive
+@password: 密码
+@identities: 指定的私钥路径
+*/
+func sshAuthMethods(password string, identities []string) []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+
+	if password != "" {
+		log.Debugf("Auth through password")
+		methods = append(methods, ssh.Password(password))
+	}
+
+	// 同一种认证方式只会尝试一次，因此所有的秘钥需要合并到一起
+	methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+		if client := sshAgent(); client != nil {
+			if res, err := client.Signers(); err != nil {
+				log.Debugf("failed to list keys in ssh-agent: %v", err)
+			} else {
+				log.Debugf("Auth through %d keys in ssh-agent", len(res))
+				signers = append(signers, res...)
+			}
+		}
+
+		for _, path := range identityFiles(identities) {
+			res, err := keySigners(path)
+			if err != nil {
+				log.Warn(err)
+				continue
+			}
+			log.Debugf("Auth through public key %s", path)
+			signers = append(signers, res...)
+		}
+		return signers, nil
+	}))
+
+	methods = append(methods, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		if instruction != "" && isTerminal() {
+			_, _ = fmt.Fprintln(os.Stderr, instruction)
+		}
+
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			// 不回显的问题通常为密码
+			if password != "" && !echos[i] {
+				answers[i] = password
+				continue
+			}
+
+			answer, err := prompt(question, echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+		return answers, nil
+	}))
+	return methods
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sshHostConfig ~/.ssh/config中与某个主机相关的配置
+type sshHostConfig struct {
+	HostName      string
+	Port          string
+	User          string
+	IdentityFiles []string
+	ProxyJump     string
+}
+
+// sshConfigFiles 依次读取的ssh配置文件，用户配置优先于系统配置
+func sshConfigFiles() []string {
+	return []string{
+		filepath.Join(os.Getenv("HOME"), ".ssh", "config"),
+		"/etc/ssh/ssh_config",
+	}
+}
+
+/*
+splitSSHConfigLine 将配置行拆分为关键字和参数，支持"key value"、"key=value"和带引号的参数
+@line: 配置行
+*/
+func splitSSHConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	idx := strings.IndexAny(line, " \t=")
+	if idx < 0 {
+		return strings.ToLower(line), nil
+	}
+	key := strings.ToLower(line[:idx])
+	rest := strings.TrimLeft(strings.TrimSpace(line[idx:]), "=")
+
+	var args []string
+	var current strings.Builder
+	quoted, started := false, false
+	for _, c := range strings.TrimSpace(rest) {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			started = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(c)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, current.String())
+	}
+	return key, args
+}
+
+/*
+matchSSHHost 检查主机是否符合Host的模式，!开头的模式表示排除
+@patterns: Host后的所有模式
+@host: 主机名
+*/
+func matchSSHHost(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, _ := path.Match(strings.ToLower(strings.TrimPrefix(pattern, "!")), strings.ToLower(host))
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+/*
+expandSSHTokens 展开配置中的~以及%h、%u、%r、%d等占位符
+@value: 配置的值
+@alias: 命令行中的主机名
+@remoteUser: 登录的用户名
+*/
+func expandSSHTokens(value, alias, remoteUser string) string {
+	home := os.Getenv("HOME")
+	if strings.HasPrefix(value, "~/") {
+		value = filepath.Join(home, value[2:])
+	}
+
+	localUser := ""
+	if u, err := user.Current(); err == nil {
+		localUser = u.Username
+	}
+	return strings.NewReplacer("%%", "%", "%h", alias, "%u", localUser, "%r", remoteUser, "%d", home).Replace(value)
+}
+
+/*
+parseSSHConfig 读取ssh配置文件，将与主机相关的配置写入config，已经设置的值不会被覆盖
+@file: 配置文件
+@alias: 命令行中的主机名
+@config: 主机的配置
+@depth: Include的嵌套层数
+*/
+func parseSSHConfig(file, alias string, config *sshHostConfig, depth int) error {
+	if depth > 16 {
+		return fmt.Errorf("too many nested include in %s", file)
+	}
+
+	r, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	active := true
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, args := splitSSHConfigLine(scanner.Text())
+		if key == "" {
+			continue
+		}
+
+		switch key {
+		case "host":
+			active = matchSSHHost(args, alias)
+			continue
+		case "match":
+			// 仅支持Match all
+			active = len(args) == 1 && strings.ToLower(args[0]) == "all"
+			if !active {
+				log.Debugf("unsupported Match %v in %s, skipped", args, file)
+			}
+			continue
+		}
+
+		if !active || len(args) == 0 {
+			continue
+		}
+
+		switch key {
+		case "include":
+			for _, pattern := range args {
+				pattern = expandSSHTokens(pattern, alias, config.User)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(os.Getenv("HOME"), ".ssh", pattern)
+				}
+				matches, _ := filepath.Glob(pattern)
+				for _, m := range matches {
+					if err := parseSSHConfig(m, alias, config, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		case "hostname":
+			if config.HostName == "" {
+				config.HostName = expandSSHTokens(args[0], alias, config.User)
+			}
+		case "port":
+			if config.Port == "" {
+				config.Port = args[0]
+			}
+		case "user":
+			if config.User == "" {
+				config.User = args[0]
+			}
+		case "identityfile":
+			config.IdentityFiles = append(config.IdentityFiles, args[0])
+		case "proxyjump":
+			if config.ProxyJump == "" {
+				config.ProxyJump = args[0]
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+/*
+lookupSSHConfig 返回主机在ssh配置文件中的设置
+@alias: 命令行中的主机名或别名
+*/
+func lookupSSHConfig(alias string) (*sshHostConfig, error) {
+	config := &sshHostConfig{}
+	for _, file := range sshConfigFiles() {
+		if err := parseSSHConfig(file, alias, config, 0); err != nil {
+			return nil, fmt.Errorf("failed to read ssh config %s: %v", file, err)
+		}
+	}
+
+	for i, identity := range config.IdentityFiles {
+		config.IdentityFiles[i] = expandSSHTokens(identity, alias, config.User)
+	}
+	return config, nil
+}
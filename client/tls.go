@@ -0,0 +1,62 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+/*
+tlsConfig 根据--ca-bundle、--tls-cert、--tls-key和--tls-insecure生成tls配置
+@serverName: 用于验证服务器证书的主机名
+*/
+func tlsConfig(serverName string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: opt.Insecure,
+		// ftp的数据连接需要复用控制连接的tls会话
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+
+	if opt.CaBundle != "" {
+		data, err := os.ReadFile(opt.CaBundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", opt.CaBundle)
+		}
+		config.RootCAs = pool
+	}
+
+	if opt.TlsCert != "" {
+		key := opt.TlsKey
+		if key == "" {
+			// 证书和私钥可以保存在同一个文件中
+			key = opt.TlsCert
+		}
+		cert, err := tls.LoadX509KeyPair(opt.TlsCert, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// serverTlsConfig 服务端模式下根据--tls-cert和--tls-key加载服务器证书
+func serverTlsConfig() (*tls.Config, error) {
+	key := opt.TlsKey
+	if key == "" {
+		key = opt.TlsCert
+	}
+	cert, err := tls.LoadX509KeyPair(opt.TlsCert, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %v", err)
+	}
+
+	// 关闭会话票据，部分客户端在数据连接上收到票据后会提前断开连接
+	return &tls.Config{Certificates: []tls.Certificate{cert}, SessionTicketsDisabled: true}, nil
+}
@@ -1,13 +1,17 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"github.com/k0kubun/go-ansi"
 	"github.com/schollz/progressbar/v3"
 	"github.com/ygidtu/transfer/base"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -47,6 +51,11 @@ type Transfer struct {
 	target     *File                    // 目标文件
 	bar        *progressbar.ProgressBar // 传输进度条
 	concurrent int                      // 并行数量
+	report     *Report                  // 本次传输的汇总
+	generation int                      // 客户端重新连接的次数
+	journal    *Journal                 // 任务日志
+	server     server                   // 服务端模式下的服务器
+	lock       sync.Mutex
 }
 
 // Describe 更改进度条指示文本
@@ -69,6 +78,11 @@ validate 检查要传输的两个源文件和目标文件的MD5是否对应
 @dst: 对应的目标文件
 */
 func (transfer *Transfer) validate(src *File, dst *File) (bool, error) {
+	// 目标文件不存在时无需计算校验值
+	if dst.Size == 0 && src.Size > 0 {
+		return false, nil
+	}
+
 	if err := src.GetMd5(); err != nil {
 		return false, err
 	}
@@ -81,74 +95,390 @@ func (transfer *Transfer) validate(src *File, dst *File) (bool, error) {
 }
 
 /*
-Transfer 开始传输文件
+plan 检查源文件和目标文件，生成对应的传输计划
 @src: 要传输的源文件，若transfer.source为文件，则src为该文件；否则为该目录下的子文件
 @dst: 对应的目标文件
 */
-func (transfer *Transfer) Transfer(src *File, dst *File) error {
-	log.Debugf("transfer %v -> %v", src.Path, dst.Path)
+func (transfer *Transfer) plan(src *File, dst *File) *Plan {
+	if plan := transfer.chunkPlan(src, dst); plan != nil {
+		return plan
+	}
+
 	valid, err := transfer.validate(src, dst)
+	if err != nil {
+		log.Debugf("failed to validate %v: %v", dst.Path, err)
+	}
+	plan := newPlan(src, dst, valid)
 
-	if !valid {
-		if dst.Source() == Aws && src.Source() != Local || src.Source() == Aws && dst.Source() != Local {
-			log.Fatalf("the aws only can transfer data with local, not %v %v", src.Source(), dst.Source())
+	// aws的分片上传记录了已完成的分片，可以从中断处续传
+	if plan.Action != ActionSkip && dst.Source() == Aws {
+		if uploaded := dst.client.(*AwsS3Client).uploaded(src, dst.Path); uploaded > 0 {
+			plan.Action = ActionResume
+			plan.Offset = uploaded
+			plan.Bytes = src.Size - uploaded
 		}
+	} else if plan.Action != ActionSkip && opt.Atomic {
+		// atomic模式下写入临时文件，并根据临时文件决定是否续传
+		partial := dst.Partial()
+		valid, err := transfer.validate(src, partial)
+		if err != nil {
+			log.Debugf("failed to validate %v: %v", partial.Path, err)
+		}
+		plan = newPlan(src, partial, valid)
+		plan.Target = dst.Path
+	}
 
-		if !dst.Exists() && filepath.Dir(dst.Path) != "/" {
-			err = dst.MkParent()
-			if err != nil {
-				return fmt.Errorf("failed to create directory for %v", dst.Path)
-			}
+	// 目标文件比上次中断时记录的写入位置更大，说明目标文件被其他程序修改，不能续传
+	if plan.Action == ActionResume && dst.Source() != Aws {
+		if record := transfer.journal.get(src); record != nil && record.Offset > 0 && plan.Offset > record.Offset {
+			log.Warnf("%s was modified since last transfer, transfer from beginning", plan.writer.Path)
+			plan.Action = ActionTruncate
+			plan.Offset = 0
+			plan.Bytes = src.Size
 		}
+	}
+	return plan
+}
+
+// countReader 记录已读取数据量的reader
+type countReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
 
-		trunc := src.Size < dst.Size
-		resumeFrom := int64(0)
+/*
+copy 从计划的位置顺序读取源文件并写入目标文件
+@src: 源文件
+@plan: 传输计划
+@added: 记录已传输的数据量
+*/
+func (transfer *Transfer) copy(src *File, plan *Plan, added *int64) error {
+	r, err := src.Reader(plan.Offset)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	transfer.journal.record(JournalProgress, src, plan.Offset)
+	counter := &countReader{Reader: r}
+	reader := progressbar.NewReader(counter, transfer.bar)
+	err = plan.writer.WriteAt(&reader, plan.Action == ActionTruncate)
+	atomic.AddInt64(added, counter.n)
+	if err != nil {
+		transfer.journal.record(JournalProgress, src, plan.Offset+counter.n)
+	}
+	return err
+}
+
+/*
+Transfer 开始传输文件
+@src: 要传输的源文件，若transfer.source为文件，则src为该文件；否则为该目录下的子文件
+@dst: 对应的目标文件
+*/
+func (transfer *Transfer) Transfer(src *File, dst *File) (err error) {
+	log.Debugf("transfer %v -> %v", src.Path, dst.Path)
+	plan := transfer.plan(src, dst)
 
-		if src.Size > dst.Size && dst.Size > 0 {
-			log.Warnf("resume file from %d", dst.Size)
-			resumeFrom = dst.Size
-			_ = transfer.bar.Add(int(dst.Size))
+	// 传输失败时回退进度条，重试时会重新计算进度
+	var added int64
+	progress := func(n int64) {
+		atomic.AddInt64(&added, n)
+		_ = transfer.bar.Add64(n)
+	}
+	defer func() {
+		if err != nil && added > 0 {
+			_ = transfer.bar.Add64(-added)
 		}
+	}()
 
-		if dst.Source() == Aws {
-			r, err := src.ReadSeeker()
+	if plan.Action != ActionSkip || plan.writer != dst {
+		if !dst.Exists() && filepath.Dir(dst.Path) != "/" {
+			err := dst.MkParent()
 			if err != nil {
-				return err
+				return fmt.Errorf("failed to create directory for %v", dst.Path)
 			}
-			defer r.Close()
+		}
 
-			if err := dst.Write(r); err != nil {
+		if plan.Action == ActionSkip {
+			// 临时文件已经完整，仅需重命名
+			progress(src.Size)
+		} else if _, ok := plan.writer.client.(fileWriter); ok {
+			// aws和scp需要完整上传源文件，aws续传的进度由分片上传自行汇报
+			if err := plan.writer.Write(src, progress); err != nil {
 				return err
 			}
-			transfer.bar.Add(int(src.Size))
 		} else {
-			r, err := src.Reader(resumeFrom)
-			if err != nil {
+			if plan.Action == ActionResume {
+				log.Warnf("resume file from %d", plan.Offset)
+				progress(plan.Offset)
+			}
+
+			if transfer.chunkable(src, plan) {
+				if err := transfer.chunkedCopy(src, plan, progress); err != nil {
+					return err
+				}
+			} else if err := transfer.copy(src, plan, &added); err != nil {
 				return err
 			}
-			defer r.Close()
+		}
 
-			reader := progressbar.NewReader(r, transfer.bar)
-			if err := dst.WriteAt(&reader, trunc); err != nil {
+		if err := transfer.preserve(src, plan.writer); err != nil {
+			return err
+		}
+
+		if opt.Verify {
+			if err := transfer.verify(src, plan.writer); err != nil {
 				return err
 			}
 		}
 
+		if plan.writer != dst {
+			if err := plan.writer.Rename(dst); err != nil {
+				return fmt.Errorf("failed to rename %s to %s: %v", plan.writer.Path, dst.Path, err)
+			}
+		}
+
+		if opt.Verify {
+			transfer.journal.record(JournalVerified, src, src.Size)
+		} else {
+			transfer.journal.record(JournalDone, src, src.Size)
+		}
 	} else {
-		transfer.bar.Add(int(src.Size))
+		progress(src.Size)
+		if err := transfer.preserve(src, dst); err != nil {
+			return err
+		}
+		transfer.journal.record(JournalVerified, src, src.Size)
 	}
+	transfer.report.add(plan.Action, plan.Bytes)
 	return nil
 }
 
-// Start 启动传输过程
-func (transfer *Transfer) Start() {
+/*
+preserved 检查--preserve是否包含特定属性
+@attr: times或者mode
+*/
+func preserved(attr string) bool {
+	for _, v := range strings.Split(opt.Preserve, ",") {
+		if strings.TrimSpace(v) == attr {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+preserveStat 根据--preserve返回需要保留的源文件修改时间和权限，不需要保留的属性为零值
+@src: 源文件
+*/
+func preserveStat(src *File) (time.Time, os.FileMode) {
+	var modTime time.Time
+	var mode os.FileMode
+	if preserved("times") {
+		modTime = src.ModTime
+	}
+	// ftp等无法获取权限的文件权限为0，不修改目标文件的权限
+	if preserved("mode") && src.Mode.Perm() != 0 {
+		mode = src.Mode
+	}
+	return modTime, mode
+}
+
+/*
+preserve 将源文件的修改时间和权限应用到目标文件上
+@src: 源文件
+@dst: 目标文件
+*/
+func (transfer *Transfer) preserve(src *File, dst *File) error {
+	if !preserved("times") && !preserved("mode") {
+		return nil
+	}
+
+	// aws列出的文件不包含metadata中记录的原始修改时间和权限
+	if src.Source() == Aws {
+		if stat, err := src.Stat(); err == nil {
+			src.ModTime = stat.ModTime()
+			src.Mode = stat.Mode()
+		}
+	}
+
+	modTime, mode := preserveStat(src)
+	if err := dst.SetStat(modTime, mode); err != nil {
+		return fmt.Errorf("failed to preserve stat of %s: %v", dst.Path, err)
+	}
+	return nil
+}
+
+/*
+verify 传输完成后重新计算目标文件的校验值，并与源文件对比
+@src: 源文件
+@dst: 目标文件
+*/
+func (transfer *Transfer) verify(src *File, dst *File) error {
+	if src.Md5 == "" {
+		if err := src.GetMd5(); err != nil {
+			return err
+		}
+	}
+
+	dst.Md5 = ""
+	if err := dst.GetMd5(); err != nil {
+		return fmt.Errorf("failed to get checksum of %s: %v", dst.Path, err)
+	}
+
+	if src.Md5 != dst.Md5 {
+		return fmt.Errorf("%w: %s (%s) -> %s (%s)", ErrChecksumMismatch, src.Path, src.Md5, dst.Path, dst.Md5)
+	}
+	log.Debugf("verified %s: %s", dst.Path, dst.Md5)
+	return nil
+}
+
+/*
+orphans 列出目标目录下源目录中不存在的文件
+@files: 源目录下的所有文件
+*/
+func (transfer *Transfer) orphans(files FileList) ([]*File, error) {
+	if transfer.source.IsFile {
+		log.Debugf("source is a file, skip sync")
+		return nil, nil
+	}
+
+	if len(files.Files) < 1 {
+		return nil, fmt.Errorf("refuse to sync from empty source: %s", transfer.source.Path)
+	}
+
+	if !transfer.target.Exists() {
+		return nil, nil
+	}
+
+	targets, err := transfer.target.Children()
+	if err != nil {
+		return nil, err
+	}
+
+	expected := make(map[string]bool)
+	for _, f := range files.Files {
+		expected[filepath.Clean(f.GetTargetPath(transfer.source, transfer.target))] = true
+	}
+
+	root := strings.TrimRight(filepath.Clean(transfer.target.Path), "/") + "/"
+	if root == "./" {
+		root = ""
+	}
+	var orphans []*File
+	for _, f := range targets.Files {
+		path := filepath.Clean(f.Path)
+		// 仅处理target目录下的文件，防止s3等前缀匹配误删
+		if !strings.HasPrefix(path, root) || expected[path] || f.IsPartial() {
+			continue
+		}
+		orphans = append(orphans, f)
+	}
+
+	if opt.MaxDelete >= 0 && len(orphans) > opt.MaxDelete {
+		return nil, fmt.Errorf("refuse to delete %d files from %s, which exceed --max-delete %d", len(orphans), transfer.target.Path, opt.MaxDelete)
+	}
+	return orphans, nil
+}
+
+/*
+sync 删除目标目录下源目录中不存在的文件
+@files: 源目录下的所有文件
+*/
+func (transfer *Transfer) sync(files FileList) error {
+	orphans, err := transfer.orphans(files)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range orphans {
+		log.Infof("delete %s", f.Path)
+		if err := f.Remove(); err != nil {
+			log.Warnf("failed to delete %s: %v", f.Path, err)
+		} else {
+			transfer.report.add(ActionDelete, 0)
+		}
+	}
+	return nil
+}
+
+/*
+dryRun 仅输出每个文件的传输计划，不做任何写入
+@w: 输出对象
+@files: 源目录下的所有文件
+*/
+func (transfer *Transfer) dryRun(w io.Writer, files FileList) {
+	var plans []*Plan
+	for _, f := range files.Files {
+		if opt.Resume != "" && transfer.journal.finished(f) {
+			plans = append(plans, &Plan{Action: ActionSkip, Source: f.Path, Target: f.GetTargetPath(transfer.source, transfer.target)})
+			continue
+		}
+		plans = append(plans, transfer.plan(f, transfer.GetTarget(f)))
+	}
+
+	if opt.Sync {
+		orphans, err := transfer.orphans(files)
+		if err != nil {
+			log.Warn(err)
+		}
+		for _, f := range orphans {
+			plans = append(plans, &Plan{Action: ActionDelete, Target: f.Path, Bytes: f.Size})
+		}
+	}
+
+	printPlans(w, plans)
+}
+
+// Start 启动传输过程，返回本次传输的汇总
+func (transfer *Transfer) Start() *Report {
+	report := newReport(transfer.source, transfer.target)
 	if transfer.target == nil {
-		if err := transfer.source.client.(*HttpClient).startServer(); err != nil {
+		if err := transfer.server.startServer(); err != nil {
 			log.Fatal(err)
 		}
-		return
+		return report
+	}
+
+	log.Debugf("source = %v", transfer.source)
+	transfer.report = report
+	if transfer.journal != nil {
+		report.Job = transfer.journal.ID
+		// 守护模式下每次运行都重新打开上次运行结束时关闭的日志
+		if !opt.DryRun {
+			if err := transfer.journal.open(opt.Source, opt.Target); err != nil {
+				log.Fatal(err)
+			}
+		}
+		defer func() {
+			if err := transfer.journal.close(); err != nil {
+				log.Warnf("failed to close journal: %v", err)
+			}
+		}()
 	}
 
+	var files FileList
+	err := transfer.retry("list files", func() (err error) {
+		files, err = transfer.source.Children()
+		return err
+	})
+	if err != nil {
+		base.SugaredLog.Fatal(err)
+	}
+	report.Files = len(files.Files)
+
+	if opt.DryRun {
+		transfer.dryRun(os.Stdout, files)
+		return report
+	}
+	transfer.journal.listed(files)
+
 	var wg sync.WaitGroup
 
 	taskChan := make(chan *File)
@@ -164,18 +494,27 @@ func (transfer *Transfer) Start() {
 				}
 
 				transfer.Describe(f.ID)
-				if err := transfer.Transfer(f, transfer.GetTarget(f)); err != nil {
+				// 跳过之前任务中已经完成的文件，无需再检查目标文件
+				if opt.Resume != "" && transfer.journal.finished(f) {
+					log.Debugf("skip finished %s", f.Path)
+					_ = transfer.bar.Add64(f.Size)
+					report.add(ActionSkip, 0)
+					continue
+				}
+
+				// 每次重试重新检查目标文件，从目标文件当前的大小续传
+				err := transfer.retry(f.Path, func() error {
+					return transfer.Transfer(f, transfer.GetTarget(f))
+				})
+				if err != nil {
 					base.SugaredLog.Warn(err)
+					transfer.journal.failed(f)
+					report.fail(f.Path, errors.Is(err, ErrChecksumMismatch))
 				}
 			}
 		}()
 	}
 
-	log.Debugf("source = %v", transfer.source)
-	files, err := transfer.source.Children()
-	if err != nil {
-		base.SugaredLog.Fatal(err)
-	}
 	transfer.bar = bytesBar(files.Total, "transfer")
 	log.Debugf("prepare to transfer %d files", len(files.Files))
 	for i, f := range files.Files {
@@ -186,4 +525,24 @@ func (transfer *Transfer) Start() {
 	wg.Wait()
 	_ = transfer.bar.Close()
 	fmt.Println()
+
+	for _, f := range report.Mismatches {
+		log.Errorf("checksum mismatch: %s", f)
+	}
+	if len(report.FailedFiles) > 0 {
+		log.Errorf("failed to transfer %d files after %d retries:", len(report.FailedFiles), opt.Retries)
+		for _, f := range report.FailedFiles {
+			log.Errorf("failed: %s", f)
+		}
+	}
+
+	if opt.Sync {
+		if err := transfer.sync(files); err != nil {
+			log.Warn(err)
+		}
+	}
+
+	report.finish()
+	report.Print(os.Stdout)
+	return report
 }
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/ygidtu/transfer/base/fi"
+)
+
+/*
+snapshot 记录目录下所有文件和文件夹的路径、权限、大小、修改时间及内容的md5
+@t: 当前测试
+@root: 目录
+*/
+func snapshot(t *testing.T, root string) map[string]string {
+	res := map[string]string{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		value := fmt.Sprintf("%v %d %v", info.Mode(), info.Size(), info.ModTime().UnixNano())
+		if info.Mode().IsRegular() {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			value += fmt.Sprintf(" %x", md5.Sum(data))
+		}
+		res[path] = value
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestDryRun(t *testing.T) {
+	saved := *opt
+	t.Cleanup(func() { *opt = saved })
+
+	source := t.TempDir()
+	for path, content := range map[string]string{
+		"a.txt":         "0123456789",
+		"dir/b.txt":     "abcdefghij",
+		"dir/sub/c.txt": "ABCDEFGHIJ",
+	} {
+		if err := os.MkdirAll(filepath.Join(source, filepath.Dir(path)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(source, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T) (string, string, Client) // 返回目标的根目录、目标路径和客户端
+	}{
+		{"local", func(t *testing.T) (string, string, Client) {
+			root := t.TempDir()
+			return root, filepath.Join(root, "target"), NewLocal()
+		}},
+		{"sftp", func(t *testing.T) (string, string, Client) {
+			root, client := newTestSftp(t)
+			return root, "/target", client
+		}},
+		{"ftp", func(t *testing.T) (string, string, Client) {
+			root, addr := newTestFtp(t)
+			host, err := CreateProxy(fmt.Sprintf("ftp://user:secret@%s/?tls=explicit", addr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := NewFtp(host)
+			if err := client.connect(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = client.close() })
+			return root, "/target", client
+		}},
+		{"s3", func(t *testing.T) (string, string, Client) {
+			ts := newTestS3(t)
+			return ts.root, "target", ts.client
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, path, client := test.setup(t)
+			local := filepath.Join(root, filepath.FromSlash(path))
+			if test.name == "local" {
+				local = path
+			} else if test.name == "s3" {
+				local = filepath.Join(root, "bucket", path)
+			}
+
+			// 目标中已有部分传输的文件、完整的文件和源文件中不存在的文件，其余的文件和目录均不存在
+			for name, content := range map[string]string{"a.txt": "01234", "dir/b.txt": "abcdefghij", "orphan.txt": "orphan"} {
+				if err := os.MkdirAll(filepath.Join(local, filepath.Dir(name)), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(local, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := snapshot(t, root)
+
+			opt.DryRun, opt.Sync, opt.MaxDelete = true, true, -1
+			for _, atomic := range []bool{false, true} {
+				opt.Atomic = atomic
+				src, err := NewFile(source, NewLocal())
+				if err != nil {
+					t.Fatal(err)
+				}
+				target, err := NewFile(path, client)
+				if err != nil {
+					t.Fatal(err)
+				}
+				files, err := src.Children()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var output bytes.Buffer
+				(&Transfer{concurrent: 1, source: src, target: target}).dryRun(&output, files)
+
+				// aws无法续传，atomic模式下从头写入临时文件
+				expect := map[string]Action{"a.txt": ActionResume, "dir/b.txt": ActionSkip, "dir/sub/c.txt": ActionCopy, "orphan.txt": ActionDelete}
+				if test.name == "s3" {
+					expect["a.txt"] = ActionTruncate
+				} else if atomic {
+					expect["a.txt"] = ActionCopy
+				}
+
+				plans := map[string]Action{}
+				lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+				for _, line := range lines[:len(lines)-1] {
+					if fields := strings.Fields(line); len(fields) > 2 {
+						plans[strings.TrimPrefix(fields[len(fields)-1], path+"/")] = Action(fields[0])
+					}
+				}
+				if !reflect.DeepEqual(plans, expect) {
+					t.Errorf("atomic %v: expect plans %v, got:\n%s", atomic, expect, output.String())
+				}
+				if summary := "1 to delete"; !strings.Contains(lines[len(lines)-1], summary) {
+					t.Errorf("atomic %v: unexpected summary %s", atomic, lines[len(lines)-1])
+				}
+			}
+
+			if after := snapshot(t, root); !reflect.DeepEqual(before, after) {
+				t.Errorf("target is changed by dry run:\nbefore: %v\nafter: %v", before, after)
+			}
+		})
+	}
+}
+
+func TestSyncSameNameAsTarget(t *testing.T) {
+	saved := *opt
+	t.Cleanup(func() { *opt = saved })
+	opt.Sync, opt.MaxDelete = true, -1
+
+	// 源目录的子目录中存在与目标目录同名的文件
+	root := t.TempDir()
+	for _, path := range []string{"src/data/x/data", "src/data/y.txt", "dst/data/x/data", "dst/data/y.txt", "dst/data/orphan.txt"} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src, err := NewFile(filepath.Join(root, "src", "data"), NewLocal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := NewFile(filepath.Join(root, "dst", "data"), NewLocal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := src.Children()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files.Files {
+		rel, _ := filepath.Rel(src.Path, f.Path)
+		if expect := filepath.Join(target.Path, rel); f.GetTargetPath(src, target) != expect {
+			t.Errorf("target of %s is %s, expect %s", f.Path, f.GetTargetPath(src, target), expect)
+		}
+	}
+
+	transfer := &Transfer{concurrent: 1, source: src, target: target, report: newReport(src, target)}
+	if err := transfer.sync(files); err != nil {
+		t.Fatal(err)
+	}
+	for path, exists := range map[string]bool{"x/data": true, "y.txt": true, "orphan.txt": false} {
+		if _, err := os.Stat(filepath.Join(root, "dst", "data", path)); (err == nil) != exists {
+			t.Errorf("expect %s exists: %v, got %v", path, exists, err)
+		}
+	}
+}
+
+func TestPreserveStat(t *testing.T) {
+	saved := *opt
+	t.Cleanup(func() { *opt = saved })
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		preserve string
+		mode     os.FileMode
+		expect   os.FileMode
+	}{
+		{"times,mode", 0640, 0640},
+		{"times", 0640, 0},
+		// ftp等无法获取权限的文件不修改目标文件的权限
+		{"times,mode", 0, 0},
+		{"mode", fi.FtpFileInfo{File: &ftp.Entry{Type: ftp.EntryTypeFile}}.Mode(), 0},
+	}
+	for _, test := range tests {
+		opt.Preserve = test.preserve
+		mtime, mode := preserveStat(&File{ModTime: modTime, Mode: test.mode})
+		if mode != test.expect || mtime.IsZero() != !strings.Contains(test.preserve, "times") {
+			t.Errorf("preserve %s of %v: got %v %v", test.preserve, test.mode, mtime, mode)
+		}
+	}
+}
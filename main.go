@@ -29,13 +29,22 @@ func main() {
 	if opt.Concurrent < 1 {
 		opt.Concurrent = 1
 	}
+	if opt.Sessions < 1 {
+		opt.Sessions = opt.Concurrent
+	}
+	if opt.Conns < 1 {
+		opt.Conns = 1
+	}
+	if opt.Conns > opt.Sessions {
+		opt.Conns = opt.Sessions
+	}
 
-	// check the input source and target
-	if opt.Source == "" {
+	// check the input source and target, which could be loaded from the job to resume
+	if opt.Source == "" && opt.Resume == "" {
 		base.SugaredLog.Fatal("please set source file/directory")
 	}
 
-	if opt.Target == "" {
+	if opt.Target == "" && opt.Server == "" && opt.Resume == "" {
 		base.SugaredLog.Fatal("please set target file/directory")
 	}
 
@@ -47,9 +56,21 @@ func main() {
 
 	if opt.Daemon {
 		sched := clockwork.NewScheduler()
-		sched.Schedule().Every(1).Days().At("12:30").Do(cli.Start)
+		sched.Schedule().Every(1).Days().At("12:30").Do(func() { writeReport(opt, cli.Start()) })
 		sched.Run()
 	} else {
-		cli.Start()
+		report := cli.Start()
+		writeReport(opt, report)
+		os.Exit(report.ExitCode())
+	}
+}
+
+// writeReport write the summary of transfer into json file if --report is set
+func writeReport(opt *base.Options, report *client.Report) {
+	if opt.Report == "" || opt.DryRun || opt.Server != "" {
+		return
+	}
+	if err := report.Write(opt.Report); err != nil {
+		base.SugaredLog.Warnf("failed to write report %s: %v", opt.Report, err)
 	}
 }